@@ -0,0 +1,95 @@
+package breaker
+
+import "time"
+
+// maxTripProbe bounds the search of the failure count at which a ReadyToTrip function trips
+const maxTripProbe = 1000
+
+// GobreakerCounts mirrors gobreaker.Counts as seen by ReadyToTrip
+type GobreakerCounts struct {
+	Requests             uint32
+	TotalSuccesses       uint32
+	TotalFailures        uint32
+	ConsecutiveSuccesses uint32
+	ConsecutiveFailures  uint32
+}
+
+// GobreakerSettings mirrors gobreaker.Settings
+type GobreakerSettings struct {
+	Name        string
+	MaxRequests uint32        // Successful trials closing a half open circuit, trials are admitted one at a time
+	Interval    time.Duration // Not mapped, failures are counted consecutively and reset by a success
+	Timeout     time.Duration // Time spent open before going half open, defaults to 60s
+	ReadyToTrip func(counts GobreakerCounts) bool
+}
+
+// FromGobreakerSettings creates a breaker behaving like a gobreaker configured with s. Gobreaker has
+// neither call timeout nor concurrency limit, they are given here. ReadyToTrip is translated to a
+// failure threshold, the smallest run of consecutive failures it trips on, so a ReadyToTrip based on
+// ratios of successes to failures only trips on a run of failures. It defaults to more than 5
+// consecutive failures, as in gobreaker. Further opts are applied after the translated ones
+func FromGobreakerSettings(s GobreakerSettings, timeout time.Duration, concurrency int, opts ...Option) *Breaker {
+	readyToTrip := s.ReadyToTrip
+	if readyToTrip == nil {
+		readyToTrip = func(counts GobreakerCounts) bool { return counts.ConsecutiveFailures > 5 }
+	}
+	threshold := 0
+	for n := uint32(1); n <= maxTripProbe; n++ {
+		if readyToTrip(GobreakerCounts{Requests: n, TotalFailures: n, ConsecutiveFailures: n}) {
+			threshold = int(n)
+			break
+		}
+	}
+	open := s.Timeout
+	if open <= 0 {
+		open = 60 * time.Second
+	}
+	base := []Option{
+		WithTimeout(timeout),
+		WithConcurrency(concurrency),
+		WithFailureThreshold(threshold),
+		WithSuccessThreshold(int(s.MaxRequests)),
+		withHealthCheckInterval(open),
+	}
+	return NewWithOptions(s.Name, append(base, opts...)...)
+}
+
+// HystrixConfig mirrors hystrix.CommandConfig, zero fields take the hystrix defaults
+type HystrixConfig struct {
+	Timeout                int // In milliseconds, defaults to 1000
+	MaxConcurrentRequests  int // Defaults to 10
+	RequestVolumeThreshold int // Defaults to 20
+	SleepWindow            int // In milliseconds, defaults to 5000
+	ErrorPercentThreshold  int // Not mapped
+}
+
+// FromHystrixConfig creates a breaker behaving like a hystrix command configured with c. Hystrix trips
+// on an error percentage over a rolling window once RequestVolumeThreshold calls were made, this is
+// translated to RequestVolumeThreshold consecutive failures, the only run that trips hystrix whatever
+// ErrorPercentThreshold. Unlike hystrix, saturation trips the circuit. Further opts are applied after
+// the translated ones
+func FromHystrixConfig(name string, c HystrixConfig, opts ...Option) *Breaker {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	concurrency := c.MaxConcurrentRequests
+	if concurrency <= 0 {
+		concurrency = 10
+	}
+	volume := c.RequestVolumeThreshold
+	if volume <= 0 {
+		volume = 20
+	}
+	sleep := c.SleepWindow
+	if sleep <= 0 {
+		sleep = 5000
+	}
+	base := []Option{
+		WithTimeout(time.Duration(timeout) * time.Millisecond),
+		WithConcurrency(concurrency),
+		WithFailureThreshold(volume),
+		withHealthCheckInterval(time.Duration(sleep) * time.Millisecond),
+	}
+	return NewWithOptions(name, append(base, opts...)...)
+}
@@ -0,0 +1,53 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+// failuresToTrip counts the failed calls it takes to open the circuit
+func failuresToTrip(b *Breaker) int {
+	for n := 1; n <= 100; n++ {
+		<-b.Execute(&panicker{})
+		if b.State() == StateOpen {
+			return n
+		}
+	}
+	return 0
+}
+
+func Test_from_gobreaker_settings(t *testing.T) {
+	tests := []struct {
+		name        string
+		readyToTrip func(counts GobreakerCounts) bool
+		want        int
+	}{
+		{"default", nil, 6},
+		{"consecutive", func(counts GobreakerCounts) bool { return counts.ConsecutiveFailures >= 3 }, 3},
+		{"ratio", func(counts GobreakerCounts) bool {
+			return counts.Requests >= 4 && float64(counts.TotalFailures)/float64(counts.Requests) >= 0.5
+		}, 4},
+	}
+	for _, tt := range tests {
+		b := FromGobreakerSettings(GobreakerSettings{Name: tt.name, ReadyToTrip: tt.readyToTrip}, time.Second, 1)
+		if got := failuresToTrip(b); got != tt.want {
+			t.Errorf("%s: Was expecting to trip after %d failures, instead got %d", tt.name, tt.want, got)
+		}
+		if got := b.Config().HealthCheckInterval; got != time.Minute {
+			t.Errorf("%s: Was expecting 60s open timeout, instead got %v", tt.name, got)
+		}
+		b.Shutdown()
+	}
+}
+
+func Test_from_hystrix_config(t *testing.T) {
+	b := FromHystrixConfig("name", HystrixConfig{RequestVolumeThreshold: 4, SleepWindow: 100000})
+	defer b.Shutdown()
+	cfg := b.Config()
+	if cfg.Timeout != time.Second || cfg.Concurrency != 10 || cfg.HealthCheckInterval != 100*time.Second {
+		t.Errorf("Was expecting hystrix defaults, instead got %+v", cfg)
+	}
+	if got := failuresToTrip(b); got != 4 {
+		t.Errorf("Was expecting to trip after 4 failures, instead got %d", got)
+	}
+}
@@ -0,0 +1,61 @@
+package breaker
+
+import "time"
+
+// emaWeight is the weight of the latest latency in the moving average
+const emaWeight = 0.2
+
+// adaptive derives the timeout from the moving average of successful latencies, see WithAdaptiveTimeout
+type adaptive struct {
+	multiplier float64
+	min, max   time.Duration
+	ema        time.Duration // Zero until the first success
+}
+
+// WithAdaptiveTimeout derives the timeout of a call from the exponential moving average of the
+// latencies of successful calls, multiplied by multiplier and clamped to [min, max]. The configured
+// timeout applies until the first success. Commands implementing Timeout keep their own timeout
+func WithAdaptiveTimeout(multiplier float64, min, max time.Duration) Option {
+	return func(b *Breaker) { b.adaptive = &adaptive{multiplier: multiplier, min: min, max: max} }
+}
+
+// observeLatency feeds the latency of a successful call to the moving average
+func (b *Breaker) observeLatency(d time.Duration) {
+	if b.adaptive == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := b.adaptive
+	if a.ema == 0 {
+		a.ema = d
+		return
+	}
+	a.ema = time.Duration(emaWeight*float64(d) + (1-emaWeight)*float64(a.ema))
+}
+
+// currentTimeout is the timeout for calls to commands not implementing Timeout
+func (b *Breaker) currentTimeout() time.Duration {
+	if b.adaptive == nil {
+		return b.timeout
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.adaptedTimeout()
+}
+
+// adaptedTimeout must be called with mu held
+func (b *Breaker) adaptedTimeout() time.Duration {
+	a := b.adaptive
+	if a == nil || a.ema == 0 {
+		return b.timeout
+	}
+	t := time.Duration(float64(a.ema) * a.multiplier)
+	if t < a.min {
+		t = a.min
+	}
+	if a.max > 0 && t > a.max {
+		t = a.max
+	}
+	return t
+}
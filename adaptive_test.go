@@ -0,0 +1,41 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_adaptive_timeout(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithAdaptiveTimeout(3, 10*time.Millisecond, 500*time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if got := b.currentTimeout(); got != time.Second {
+		t.Errorf("Was expecting configured timeout before any success, instead got %v", got)
+	}
+	b.observeLatency(20 * time.Millisecond)
+	if got := b.currentTimeout(); got != 60*time.Millisecond {
+		t.Errorf("Was expecting 60ms, instead got %v", got)
+	}
+	// Slowing down raises the timeout gradually, up to the max
+	prev := b.currentTimeout()
+	for i := 0; i < 5; i++ {
+		b.observeLatency(100 * time.Millisecond)
+		got := b.currentTimeout()
+		if got <= prev || got > 300*time.Millisecond {
+			t.Errorf("Was expecting timeout to rise towards 300ms, instead got %v after %v", got, prev)
+		}
+		prev = got
+	}
+	for i := 0; i < 50; i++ {
+		b.observeLatency(time.Second)
+	}
+	if got := b.currentTimeout(); got != 500*time.Millisecond {
+		t.Errorf("Was expecting timeout clamped to max, instead got %v", got)
+	}
+	for i := 0; i < 50; i++ {
+		b.observeLatency(time.Microsecond)
+	}
+	if got := b.currentTimeout(); got != 10*time.Millisecond {
+		t.Errorf("Was expecting timeout clamped to min, instead got %v", got)
+	}
+}
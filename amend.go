@@ -0,0 +1,84 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// amendWindow is the number of most recent calls Amend can reclassify
+const amendWindow = 256
+
+// ErrNotAmendable is returned by Amend for a call that is unknown or too old to be reclassified
+var ErrNotAmendable = errors.New("call unknown or too old to be amended")
+
+// recorded is the outcome of a call as accounted for, kept for Amend
+type recorded struct {
+	id      uint64
+	outcome Outcome
+	d       time.Duration
+	failed  bool // Counted as a failure by the trip policy
+	tripped bool // Opened the circuit
+}
+
+// recentCalls is a ring buffer of the most recently recorded calls
+type recentCalls struct {
+	mu    sync.Mutex
+	calls [amendWindow]recorded
+	next  int
+}
+
+func (b *Breaker) remember(id uint64, outcome Outcome, d time.Duration, failed, tripped bool) {
+	if id == 0 {
+		return
+	}
+	r := b.recent
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[r.next] = recorded{id: id, outcome: outcome, d: d, failed: failed, tripped: tripped}
+	r.next = (r.next + 1) % amendWindow
+}
+
+// Amend reclassifies a recently completed call as outcome, for callers finding out after the fact
+// that a failure was their own doing, see Error.CallID. Statistics move the call to its new outcome.
+// A failure no longer counts toward the failure threshold, and the circuit closes again if that call
+// opened it and it is still open. A call amended to a failure counts as one more failure. Parent
+// breakers and OnComplete are not told. Only the last 256 calls can be amended
+func (b *Breaker) Amend(id uint64, outcome Outcome) error {
+	if outcome < 0 || outcome >= numOutcomes {
+		return errors.Errorf("invalid outcome %d", outcome)
+	}
+	if b == nil || b.recent == nil || id == 0 {
+		return ErrNotAmendable
+	}
+	r := b.recent
+	r.mu.Lock()
+	var c *recorded
+	for i := range r.calls {
+		if r.calls[i].id == id {
+			c = &r.calls[i]
+			break
+		}
+	}
+	if c == nil {
+		r.mu.Unlock()
+		return ErrNotAmendable
+	}
+	prev := *c
+	failed := b.tripsOn(outcome) && (outcome == OutcomeTimeout || outcome == OutcomePanic || outcome == OutcomeFailure)
+	c.outcome, c.failed, c.tripped = outcome, failed, prev.tripped && failed
+	r.mu.Unlock()
+	if prev.outcome == outcome {
+		return nil
+	}
+	b.latencies[prev.outcome].remove(prev.d)
+	b.latencies[outcome].record(prev.d)
+	switch {
+	case prev.failed && !failed:
+		b.apply(event{kind: eventAmend, tripped: prev.tripped})
+	case !prev.failed && failed:
+		b.apply(event{kind: eventCall, failed: true})
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package breaker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// awaiting adapts a result channel to CommandFuncs
+type awaiting[T any] struct {
+	name   string
+	result <-chan T
+	value  T
+	err    error
+}
+
+// Await applies the admission and timeout of b to waiting for a value on result, produced by work
+// already in flight. On failure fallback is called with the Error, a nil fallback returns the zero
+// value and the Error. A channel closed without a value fails the call
+func Await[T any](ctx context.Context, b *Breaker, name string, result <-chan T, fallback func(err error) (T, error), opts ...CallOption) (T, error) {
+	a := &awaiting[T]{name: name, result: result}
+	be := <-b.ExecuteContext(ctx, a, opts...)
+	if be.Success() {
+		return a.value, nil
+	}
+	if fallback != nil {
+		return fallback(be)
+	}
+	var zero T
+	return zero, be
+}
+
+func (a *awaiting[T]) CommandFuncCtx(ctx context.Context) {
+	select {
+	case v, ok := <-a.result:
+		if !ok {
+			a.err = errors.New("result channel closed without a value")
+			return
+		}
+		a.value = v
+	case <-ctx.Done():
+	}
+}
+
+func (a *awaiting[T]) failure() error { return a.err }
+func (a *awaiting[T]) CommandFunc()   { a.CommandFuncCtx(context.Background()) }
+func (a *awaiting[T]) DefaultFunc()   {}
+func (a *awaiting[T]) CleanupFunc()   {}
+func (a *awaiting[T]) Name() string   { return a.name }
@@ -0,0 +1,41 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_await(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	result := make(chan int, 1)
+	result <- 42
+	if v, err := Await[int](context.Background(), b, "ready", result, nil); err != nil || v != 42 {
+		t.Errorf("Was expecting 42, instead got %v %v", v, err)
+	}
+}
+
+func Test_await_times_out(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	slow := make(chan int)
+	var got error
+	fallback := func(err error) (int, error) {
+		got = err
+		return -1, nil
+	}
+	if v, err := Await[int](context.Background(), b, "slow", slow, fallback); err != nil || v != -1 {
+		t.Errorf("Was expecting the fallback value, instead got %v %v", v, err)
+	}
+	var be Error
+	if !errors.As(got, &be) || !be.Timeout() {
+		t.Errorf("Fallback should have been called with a timeout, instead got %v", got)
+	}
+	if _, err := Await[int](context.Background(), b, "slow", slow, nil); err == nil {
+		t.Errorf("Was expecting a timeout without fallback")
+	}
+}
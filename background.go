@@ -0,0 +1,41 @@
+package breaker
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithBackgroundProbe keeps user traffic away from a recovering dependency: a half open circuit
+// rejects every call and probe runs in the background every interval instead, the circuit closes on
+// the first probe returning nil. Unlike WithProbe, which runs while the circuit is open, no user
+// request ever serves as the trial. An interval of 0 uses the healthcheck interval
+func WithBackgroundProbe(probe func() error, interval time.Duration) Option {
+	return func(b *Breaker) { b.backgroundProbe, b.backgroundInterval = probe, interval }
+}
+
+// probeInBackground runs the background probe while the circuit stays half open
+func (b *Breaker) probeInBackground() {
+	for {
+		interval := b.backgroundInterval
+		if interval <= 0 {
+			interval = b.healthCheckInterval()
+		}
+		select {
+		case <-time.After(interval):
+		case <-b.closing:
+			return
+		}
+		if b.State() != StateHalfOpen {
+			return
+		}
+		if err := b.backgroundProbe(); err != nil {
+			b.logEvent(EventRecovery, logrus.Fields{"error": err}, "background probe failed")
+			continue
+		}
+		if b.apply(event{kind: eventProbe}) {
+			b.logEvent(EventRecovery, nil, "circuit repaired, load it normal")
+		}
+		return
+	}
+}
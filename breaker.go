@@ -1,9 +1,13 @@
 package breaker
 
 import (
-	"fmt"
+	"context"
+	"io"
+	"math/rand"
 	"os"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,38 +24,202 @@ type CommandFuncs interface {
 	CleanupFunc() // Function called by breaker in case of timeout. client implements any cleanup actions
 }
 
-// Timeout is optionally implemented by clients to override the global circuit breaker timeout
+// ContextCommand is optionally implemented by clients whose command honours cancellation, used
+// instead of CommandFunc
+type ContextCommand interface {
+	CommandFuncCtx(ctx context.Context)
+}
+
+// failer is implemented by adapters of commands returning an error, a non nil error fails the call.
+// Read once the command has returned
+type failer interface {
+	failure() error
+}
+
+// Failer is optionally implemented by clients whose command can fail without panicking, a non nil
+// Failure fails the call with OutcomeFailure. Read once the command has returned
+type Failer interface {
+	Failure() error
+}
+
+// Timeout is optionally implemented by clients to override the global circuit breaker timeout,
+// zero keeps the timeout of the breaker
 type Timeout interface {
-	timeout() time.Duration
+	Timeout() time.Duration
 }
 
+// FailMode decides what happens to a call when the breaker itself fails, for example when a Limiter panics
+type FailMode int
+
+const (
+	FailClosed FailMode = iota // Reject the call, for correctness critical systems
+	FailOpen                   // Run the call without protection, for availability critical systems
+)
+
 // Breaker struct for circuit breaker control parameters
 type Breaker struct {
-	name                string        // For debudding purposes
-	timeout             time.Duration // Timeout at breaker level, can be reset by specific consumer
-	numConcurrent       int           // Number of concurrent requests
-	semaphore           chan bool     // Controls access to execute tasks
-	isOk                bool          // Can circuit take more load?
-	isShutdown          bool          // Has circuit been shutdown completely?
-	status              int           // States for a circuit, look at consts below
-	HealthCheckInterval time.Duration // Scanning interval to reset tripped circuit
+	name                 string         // For debudding purposes
+	timeout              time.Duration  // Timeout at breaker level, can be reset by specific consumer
+	numConcurrent        int            // Number of concurrent requests
+	limiter              Limiter        // Controls access to execute tasks
+	isOk                 bool           // Can circuit take more load?
+	isShutdown           bool           // Has circuit been shutdown completely?
+	status               int            // States for a circuit, look at consts below
+	HealthCheckInterval  time.Duration  // Scanning interval to reset tripped circuit, in ms. Change it with SetHealthCheckInterval once running
+	trigger              chan chan bool // Wakes healthcheck to run a probe immediately, used by tests
+	closing              chan struct{}  // Closed to stop healthcheck, see Close
+	closeOnce            sync.Once
+	stopped              chan struct{} // Closed when healthcheck has returned
+	clock                clock         // Source of time, replaced in tests
+	started              time.Time     // Time breaker was created, used for warmup
+	warmup               time.Duration // Circuit never trips during this period after start
+	goroutines           int64         // Number of live goroutines spawned by Execute, updated atomically
+	maxGoroutines        int64         // Execute rejects work once goroutines reaches this, 0 means no limit
+	mu                   sync.Mutex    // Guards circuit transitions and callbacks
+	onStateChange        func(name string, from, to State)
+	onShutdown           func(name string)
+	onComplete           func(name string, outcome Outcome, d time.Duration)
+	latencies            [numOutcomes]histogram // Command durations by outcome
+	waits                histogram              // Time admitted calls waited for admission
+	waitCount            int64                  // Updated atomically
+	waitTotal            int64                  // Nanoseconds, updated atomically
+	onAcquire            func(d time.Duration)
+	abandon              func(commands CommandFuncs) // See WithTimeoutReleasesResources
+	adaptive             *adaptive                   // See WithAdaptiveTimeout
+	rand                 *rand.Rand                  // Guarded by randMu, see WithRand
+	randMu               sync.Mutex
+	jitter               float64 // Fraction of HealthCheckInterval, see WithHealthCheckJitter
+	recorder             *EventRecorder
+	events               *eventStream  // See WithEvents
+	ttl                  time.Duration // See WithTTL
+	noCleanupOnRejection bool          // See WithCleanupOnRejection
+	trips                []time.Time   // Oldest first, see Stats.TripsLastHour
+	flap                 *flap
+	queued               int64 // Calls waiting for admission, updated atomically
+	running              int64 // Admitted calls awaiting their command, updated atomically
+	maxQueued            int64
+	dryRun               bool                          // See WithDryRun
+	idempotency          IdempotencyStore              // See WithIdempotencyStore
+	wouldTrip            int64                         // Trips in dry run, updated atomically
+	wouldReject          int64                         // Calls admitted in dry run that would have been rejected, updated atomically
+	rejectedOpen         int64                         // Updated atomically, see Stats.RejectedOpen
+	rejectedSaturated    int64                         // Updated atomically
+	leakTimeout          time.Duration                 // See WithLeakDetection
+	logSampling          int                           // See WithLogSampling
+	logCounts            [numEventTypes]uint64         // Events seen by type, updated atomically
+	tripOn               TripOn                        // See WithTripOn
+	startupProbe         func() error                  // See WithStartupProbe
+	serial               *sequencer                    // See WithSerializedCallbacks
+	holders              map[uint64]InFlightInfo       // Calls holding tokens, guarded by holdersMu
+	cancels              map[uint64]context.CancelFunc // Cancel the calls in holders, see ForceOpenWithCancel
+	holdersMu            sync.Mutex
+	lastHolder           uint64
+	recoverySteps        []float64           // See WithGradualRecovery
+	propagatePanics      bool                // See WithPanicPropagation
+	speculative          bool                // DefaultFunc runs alongside CommandFunc, see WithSpeculativeFallback
+	hedge                time.Duration       // See WithHedge
+	parent               *Breaker            // Calls must also be admitted by the parent, see WithParent
+	keys                 map[string]*Breaker // Trip state by key, guarded by keysMu, see ExecuteKeyed
+	keysMu               sync.Mutex
+	isKey                bool                // Keeps the trip state of a key of its parent
+	dependencies         []*Breaker          // Guarded by graphMu, see DependsOn
+	dependents           []*Breaker          // Guarded by graphMu
+	openOnDependencies   bool                // See WithOpenOnDependencies
+	forced               bool                // Pinned open by ForceOpen, guarded by mu
+	noLogging            bool                // See WithNoLogging
+	nameValidator        func(string) string // See WithNameValidator
+	lazy                 bool                // See WithLazyRecovery
+	lastProbe            time.Time           // Last time the circuit opened or was probed, guarded by mu
+	stateChanged         chan struct{}       // Closed on the next change of state, see WaitClosed, guarded by mu
+	backgroundProbe      func() error        // See WithBackgroundProbe
+	backgroundInterval   time.Duration
+	admission            func(ctx context.Context, name string) (bool, error) // See WithAdmissionFunc
+	lastCall             uint64                                               // Id of the last call, updated atomically
+	recent               *recentCalls                                         // See Amend
+	probeFunc            func() error                                         // Decides whether a tripped circuit is repaired, see WithProbe
+	classifier           func(err error) Outcome                              // Accounts for a done context, see WithClassifier
+	transitions          *transitionLog                                       // Recent transitions, see WithTransitionHistory
+	failMode             FailMode                                             // Behavior when the breaker itself fails, see WithFailMode
+	failureThreshold     int                                                  // Consecutive failures that trip the circuit, 0 means never
+	failurePredicate     func(Error) bool                                     // Decides which calls are failures, see WithFailurePredicate
+	failures             int                                                  // Consecutive failures, guarded by mu
+	halfOpen             bool                                                 // Circuit is waiting for a trial call to decide, guarded by mu
+	trial                bool                                                 // Trial call is in flight, guarded by mu
+	successes            int                                                  // Successful trials while half open, guarded by mu
+	successThreshold     int
+	halfOpenTimeout      time.Duration       // See WithHalfOpenTimeout
+	recoveryPolicy       func(*Breaker) bool // Decides whether a tripped circuit is repaired, see WithRecoveryPolicy
+	totalBudget          time.Duration       // Time to result including admission, see WithTotalBudget
+	log                  *logrus.Logger
+	logLevels            map[EventType]logrus.Level // Overrides of defaultLogLevels, see WithLogLevels
 }
 
-var log *logrus.Logger
+// ErrNotInitialized is returned by Execute on a Breaker that was not created with New or NewWithOptions
+var ErrNotInitialized = errors.New("breaker not initialized, create it with New")
 
 // New initializes the circuit breaker
 func New(name string, timeout time.Duration, numConcurrent int) *Breaker {
-	b := Breaker{}
+	return NewWithOptions(name, WithTimeout(timeout), WithConcurrency(numConcurrent))
+}
+
+// NewWithOptions initializes the circuit breaker, options are applied in order
+func NewWithOptions(name string, opts ...Option) *Breaker {
+	return configure(name, opts).start()
+}
+
+// configure creates a breaker with the defaults and opts applied
+func configure(name string, opts []Option) *Breaker {
+	b := &Breaker{}
 	b.name = name
-	b.timeout = timeout
-	b.numConcurrent = numConcurrent
-	b.semaphore = make(chan bool, b.numConcurrent)
 	b.isOk = true
 	b.HealthCheckInterval = 100 // Defaulted to 100 ms, can be overridden
-	log = initLog()
-	log.Formatter = new(logrus.JSONFormatter)
-	go healthcheck(&b) // Start goroutine to start healthcheck
-	return &b
+	b.trigger = make(chan chan bool)
+	b.closing = make(chan struct{})
+	b.stopped = make(chan struct{})
+	b.transitions = newTransitionLog(10)
+	b.recent = &recentCalls{}
+	b.clock = realClock{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// start completes a configured breaker and starts its healthcheck
+func (b *Breaker) start() *Breaker {
+	b.started = b.clock.Now()
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(b.started.UnixNano()))
+	}
+	if b.limiter == nil {
+		b.limiter = newChanLimiter(b.numConcurrent)
+	}
+	b.log = initLog()
+	b.log.Formatter = new(logrus.JSONFormatter)
+	if b.startupProbe != nil {
+		if err := b.startupProbe(); err != nil {
+			b.logEvent(EventRecovery, logrus.Fields{"error": err}, "startup probe failed")
+			b.apply(event{kind: eventTrip, reason: "startup probe failed"})
+		}
+	}
+	if b.lazy {
+		// No goroutine to wait for, see lazyRecovery
+		close(b.stopped)
+		return b
+	}
+	go healthcheck(b) // Start goroutine to start healthcheck
+	return b
+}
+
+// WithParent nests the breaker under parent, calls are admitted by the parent first and then
+// by this breaker. A parent that is open or saturated rejects calls of all its children, a child
+// that trips does not affect its siblings. The parent is recorded as depending on b, see DependsOn
+func (b *Breaker) WithParent(parent *Breaker) *Breaker {
+	b.parent = parent
+	if parent != nil {
+		parent.DependsOn(b)
+	}
+	return b
 }
 
 func initLog() *logrus.Logger {
@@ -69,102 +237,832 @@ const (
 )
 
 func healthcheck(b *Breaker) {
+	defer close(b.stopped)
+	var expired <-chan time.Time
+	if b.ttl > 0 {
+		t := time.NewTimer(b.ttl)
+		defer t.Stop()
+		expired = t.C
+	}
 	for {
-		if b.isShutdown {
+		if b.State() == StateShutdown {
 			return
 		}
-		time.Sleep(b.HealthCheckInterval * time.Millisecond)
-		if !b.isOk {
-			select {
-			case b.semaphore <- true:
-				<-b.semaphore
-				b.closeCircuit()
-				fmt.Println("repaired")
-				log.WithFields(logrus.Fields{"name": b.name}).Info("circuit repaired, load it normal")
-				b.status = iCircuitGood
-			default:
-				fmt.Println("circuit still bad")
-				log.WithFields(logrus.Fields{"name": b.name}).Info("attempt to repair circuit failed")
-				b.status = iCircuitStillBad
-			}
+		var done chan bool
+		select {
+		case <-time.After(b.jittered(b.healthCheckInterval())):
+		case done = <-b.trigger:
+		case <-b.closing:
+			return
+		case <-expired:
+			b.Shutdown()
+			return
+		}
+		b.probe()
+		if done != nil {
+			done <- true
 		}
 	}
 }
 
-func (b *Breaker) openCircuit() bool {
-	b.isOk = false
-	b.status = iCircuitStillBad
-	return b.isOk
+// probe runs one repair attempt of a tripped circuit. Without a recovery policy or a probe function
+// the circuit goes half open and the next call decides
+func (b *Breaker) probe() {
+	if b.State() != StateOpen {
+		return
+	}
+	b.mu.Lock()
+	recoveryPolicy, probeFunc, forced := b.recoveryPolicy, b.probeFunc, b.forced
+	b.mu.Unlock()
+	if forced {
+		return
+	}
+	var ok bool
+	switch {
+	case recoveryPolicy != nil:
+		ok = recoveryPolicy(b)
+	case probeFunc != nil:
+		err := probeFunc()
+		if err != nil {
+			b.logEvent(EventRecovery, logrus.Fields{"error": err}, "probe failed")
+		}
+		ok = err == nil
+	default:
+		if b.apply(event{kind: eventTick}) {
+			b.logEvent(EventRecovery, nil, "circuit half open, awaiting trial")
+		}
+		return
+	}
+	if ok {
+		if b.apply(event{kind: eventProbe}) {
+			b.logEvent(EventRecovery, nil, "circuit repaired, load it normal")
+		}
+	} else {
+		b.logEvent(EventRecovery, nil, "attempt to repair circuit failed")
+		b.apply(event{kind: eventProbe, failed: true})
+	}
 }
 
-func (b *Breaker) closeCircuit() bool {
-	b.isOk = true
-	b.status = iCircuitGood
-	return b.isOk
+// CapacityAvailable is a recovery policy considering the circuit repaired as soon as it has capacity
+// to take a call, see WithRecoveryPolicy
+func CapacityAvailable(b *Breaker) bool {
+	if b.limiter.Acquire(context.Background()) {
+		b.limiter.Release()
+		return true
+	}
+	return false
 }
 
-var mutex = &sync.Mutex{}
+// healthCheckInterval is read by the healthcheck on every iteration so changes apply at once
+func (b *Breaker) healthCheckInterval() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.HealthCheckInterval * time.Millisecond
+}
+
+// SetHealthCheckInterval changes the interval of the running healthcheck, safe to call concurrently.
+// The interval being waited on completes first
+func (b *Breaker) SetHealthCheckInterval(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.HealthCheckInterval = d / time.Millisecond
+}
 
-// Shutdown is called by clients to completely stop circuit breaker from taking any more load
-func (b *Breaker) Shutdown() {
-	if b.isShutdown {
+// SetRecoveryPolicy changes the recovery policy of the running healthcheck, see WithRecoveryPolicy
+func (b *Breaker) SetRecoveryPolicy(repaired func(*Breaker) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.recoveryPolicy = repaired
+}
+
+// triggerHealthCheck wakes the healthcheck goroutine and waits for one probe cycle to complete.
+// Lets tests drive recovery without waiting on HealthCheckInterval
+func (b *Breaker) triggerHealthCheck() {
+	if b.lazy {
+		b.probe()
 		return
 	}
-	mutex.Lock()
-	b.isShutdown = true
-	mutex.Unlock()
-	b.status = iShutdown
+	done := make(chan bool, 1)
+	select {
+	case b.trigger <- done:
+		<-done
+	case <-b.stopped:
+	}
+}
+
+// Breaker is an io.Closer so it can be released with defer b.Close()
+var _ io.Closer = (*Breaker)(nil)
+
+// Close stops the healthcheck goroutine, the state of the circuit is left intact and Execute
+// keeps working, but a tripped circuit is no longer repaired. Safe to call more than once.
+// Unlike Shutdown, which also stops the healthcheck but rejects all further work, Close only
+// releases the resources of the breaker. Returns ErrNotInitialized for a breaker not created with New
+func (b *Breaker) Close() error {
+	if b == nil || b.limiter == nil {
+		return ErrNotInitialized
+	}
+	b.closeOnce.Do(func() { close(b.closing) })
+	<-b.stopped
+	return nil
+}
+
+// trip opens the circuit unless the breaker is still warming up
+func (b *Breaker) trip(reason string) {
+	if b.warmingUp() {
+		return
+	}
+	b.apply(event{kind: eventTrip, reason: reason})
+}
+
+func (b *Breaker) warmingUp() bool {
+	return b.since(b.started) < b.warmup
+}
+
+// Shutdown is called by clients to completely stop circuit breaker from taking any more load.
+// Returns true only for the caller that actually shut the circuit down, never for a breaker not
+// created with New
+func (b *Breaker) Shutdown() bool {
+	if b == nil || b.limiter == nil {
+		return false
+	}
+	if !b.apply(event{kind: eventShutdown}) {
+		return false
+	}
+	b.closeOnce.Do(func() { close(b.closing) })
+	b.shutdownKeys()
+	if b.events != nil {
+		// Already closed by the transition to shutdown, unless it was not logged
+		b.events.close()
+	}
+	b.mu.Lock()
+	f := b.onShutdown
+	b.mu.Unlock()
+	if f != nil {
+		f(b.name)
+	}
+	return true
+}
+
+// Execute is called by clients to initiate task. New code should prefer ExecuteResult, which does not
+// report a successful call as an Error
+func (b *Breaker) Execute(commands CommandFuncs, opts ...CallOption) chan Error {
+	return b.ExecuteContext(context.Background(), commands, opts...)
 }
 
-// Execute is called by clients to initiate task
-func (b *Breaker) Execute(commands CommandFuncs) chan Error {
+// ExecuteContext is Execute bounded by ctx. Commands implementing ContextCommand receive a context
+// that is done when ctx is done or the command times out. How a done ctx is accounted for is
+// decided by the classifier, see WithClassifier. A ctx that is already done is rejected without
+// taking a token, the call is ignored by the trip policy. The same holds for the trial of a half open
+// circuit, by default a trial past its ctx deadline reopens the circuit while a canceled trial
+// leaves it half open for the next one
+func (b *Breaker) ExecuteContext(ctx context.Context, commands CommandFuncs, opts ...CallOption) chan Error {
 	errorch := make(chan Error, 1)
-	if b.isShutdown {
-		be := Error{Err: errors.New("circuit has been permanently shutdown. create a new one")}
+	b.execute(ctx, commands, opts, func(r Result, be Error) {
 		errorch <- be
-		return errorch
+		b.watchLeak(commands, func() bool { return len(errorch) > 0 })
+	})
+	return errorch
+}
+
+// ExecuteResult is the preferred form of ExecuteContext, a successful call is not reported as an Error
+func (b *Breaker) ExecuteResult(ctx context.Context, commands CommandFuncs, opts ...CallOption) chan Result {
+	resultch := make(chan Result, 1)
+	b.execute(ctx, commands, opts, func(r Result, be Error) {
+		resultch <- r
+		b.watchLeak(commands, func() bool { return len(resultch) > 0 })
+	})
+	return resultch
+}
+
+// execute runs the call and hands its result to deliver, called exactly once
+func (b *Breaker) execute(ctx context.Context, commands CommandFuncs, opts []CallOption, deliver func(Result, Error)) {
+	c := newCall(opts)
+	if b == nil || b.limiter == nil {
+		be := Error{reason: ReasonInvalid, Err: ErrNotInitialized}
+		deliver(Result{Outcome: OutcomeRejected, Err: be}, be)
+		return
 	}
-	go func() {
-		select {
-		case b.semaphore <- true:
-			go func() {
+	c.serial = b.serial
+	submitted := b.clock.Now()
+	c.id = atomic.AddUint64(&b.lastCall, 1)
+	if commands == nil {
+		b.logEvent(EventInternal, nil, "nil command")
+		be := Error{reason: ReasonInvalid, name: b.name, time: submitted, Err: errors.New("nil command, cannot run your command")}
+		deliver(Result{Outcome: OutcomeRejected, Err: be}, be)
+		return
+	}
+	if c.name = b.commandName(commands); c.name == "" && b.nameValidator != nil {
+		b.logEvent(EventInternal, nil, "invalid command name")
+		be := Error{reason: ReasonInvalid, name: b.name, time: submitted, Err: errors.New("invalid command name, cannot run your command")}
+		deliver(Result{Outcome: OutcomeRejected, Err: be}, be)
+		return
+	}
+	b.lazyRecovery()
+	if b.State() == StateShutdown {
+		b.finish(deliver, commands, c, OutcomeRejected, submitted, shutdownError())
+		return
+	}
+	if err := ctx.Err(); err != nil {
+		b.fallback(c, commands)
+		b.finish(deliver, commands, c, OutcomeIgnored, submitted, Error{reason: ReasonCanceled, Err: err})
+		return
+	}
+	if b.admission != nil {
+		if ok, err := b.admission(ctx, c.name); !ok {
+			if err == nil {
+				err = errors.New("admission denied, cannot run your command")
+			}
+			b.fallback(c, commands)
+			b.logEvent(EventRejection, c.fields(logrus.Fields{"error": err}), "admission denied")
+			b.finish(deliver, commands, c, OutcomeRejected, submitted, Error{reason: ReasonDenied, Err: err})
+			return
+		}
+	}
+	// Slots for the goroutine of the call and for its command, which may outlive the call after a timeout
+	if !b.reserve(2) {
+		b.fallback(c, commands)
+		b.logEvent(EventRejection, c.fields(nil), "goroutine limit reached")
+		be := Error{reason: ReasonSaturated, Err: errors.New("reached goroutine limit, cannot run your command")}
+		b.finish(deliver, commands, c, OutcomeRejected, submitted, be)
+		return
+	}
+	o := b.owner()
+	if n := atomic.AddInt64(&o.queued, 1); o.maxQueued > 0 && n > o.maxQueued {
+		atomic.AddInt64(&o.queued, -1)
+		b.unreserve(2)
+		b.fallback(c, commands)
+		b.logEvent(EventRejection, c.fields(nil), "admission queue full")
+		be := Error{reason: ReasonSaturated, Err: errors.New("admission queue is full, cannot run your command")}
+		b.finish(deliver, commands, c, OutcomeRejected, submitted, be)
+		return
+	}
+	c.slot = true
+	b.spawnReserved(func() {
+		defer b.releaseSlot(c)
+		actx := ctx
+		if b.totalBudget > 0 {
+			var cancel context.CancelFunc
+			actx, cancel = context.WithTimeout(ctx, b.totalBudget)
+			defer cancel()
+		}
+		waitStart := b.clock.Now()
+		release, err := b.admit(actx, c)
+		atomic.AddInt64(&o.queued, -1)
+		c.queue = b.since(submitted)
+		if err != nil && err == actx.Err() {
+			// Canceled or out of time while queued
+			b.fallback(c, commands)
+			outcome := b.classify(err)
+			b.finish(deliver, commands, c, outcome, submitted, Error{isTimeout: outcome == OutcomeTimeout, reason: ReasonCanceled, Err: err})
+			return
+		}
+		if err == nil && ctx.Err() != nil {
+			// Canceled while queued, a Limiter may hand out a token to a waiter that already left
+			release()
+			b.fallback(c, commands)
+			outcome := b.classify(ctx.Err())
+			b.finish(deliver, commands, c, outcome, submitted, Error{isTimeout: outcome == OutcomeTimeout, reason: ReasonCanceled, Err: ctx.Err()})
+			return
+		}
+		if err == nil {
+			// Turn taken once admitted, a call holding a token never waits for one still queued
+			c.takeTicket()
+			b.recordWait(b.since(waitStart))
+			if b.State() == StateShutdown {
+				// Shut down while waiting for admission
+				release()
+				b.finish(deliver, commands, c, OutcomeRejected, submitted, shutdownError())
+				return
+			}
+			rctx, cancel := context.WithCancel(ctx)
+			id := b.track(c, cancel)
+			func() {
 				// Have to release token
-				defer func() { <-b.semaphore }()
-				// Channel for signalling completion of command
-				done := make(chan bool, 1)
-				go func() {
-					defer func() { done <- true }()
-					commands.CommandFunc()
-				}()
-				// Deals with timeout of command
-				select {
-				case <-time.After(b.commandTimeout(commands)):
-					// Call default and cleanup
-					commands.DefaultFunc()
-					commands.CleanupFunc()
-					log.WithFields(logrus.Fields{"name": b.name}).Info("task timed out")
-					// Return timeout error
-					be := Error{isTimeout: true, Err: errors.New("task timed out")}
-					errorch <- be
-				case <-done:
-					errorch <- Error{isSuccess: true, Err: nil}
+				defer release()
+				defer b.untrack(id)
+				defer cancel()
+				timeout := b.commandTimeout(commands)
+				if c.timeout > 0 {
+					timeout = c.timeout
+				}
+				if c.trial && b.halfOpenTimeout > 0 {
+					timeout = b.halfOpenTimeout
 				}
+				if b.totalBudget > 0 {
+					// Time spent waiting for admission eats into the budget
+					if remaining := b.totalBudget - b.since(submitted); remaining < timeout {
+						timeout = remaining
+					}
+				}
+				start := b.clock.Now()
+				outcome, be := b.run(rctx, commands, c, timeout)
+				c.service = b.since(start)
+				b.finish(deliver, commands, c, outcome, submitted, be)
 			}()
-		default:
+		} else {
+			b.logEvent(EventRejection, c.fields(logrus.Fields{"error": err}), "task rejected")
+			b.fallback(c, commands)
+			reason := reasonOf(err)
+			be := Error{isSuccess: false, isShutdown: reason == ReasonShutdown, reason: reason, Err: err}
+			if reason == ReasonOpen {
+				be.retryAfter = b.RetryAfter()
+			}
+			b.finish(deliver, commands, c, OutcomeRejected, submitted, be)
+		}
+	})
+}
+
+// maxStack bounds the stack kept for a panicked command
+const maxStack = 8 << 10
+
+// recovered is a recovered panic with the stack it was raised on
+type recovered struct {
+	value interface{}
+	stack []byte
+}
+
+// signals are the channels a command goroutine reports on, pooled to spare their allocation on every
+// call. Both run and the command goroutine hold them, the last to let go returns them to the pool
+type signals struct {
+	done     chan bool
+	panicked chan recovered
+	started  chan struct{}
+	refs     int32
+}
+
+var signalPool = sync.Pool{New: func() interface{} {
+	return &signals{done: make(chan bool, 1), panicked: make(chan recovered, 1), started: make(chan struct{}, 1)}
+}}
+
+func newSignals() *signals {
+	s := signalPool.Get().(*signals)
+	atomic.StoreInt32(&s.refs, 2)
+	return s
+}
+
+// release drops a reference, the last one drains the channels so the next call starts empty
+func (s *signals) release() {
+	if atomic.AddInt32(&s.refs, -1) > 0 {
+		return
+	}
+	select {
+	case <-s.done:
+	case <-s.panicked:
+	default:
+	}
+	select {
+	case <-s.started:
+	default:
+	}
+	signalPool.Put(s)
+}
+
+// stack returns the truncated stack of the calling goroutine
+func stack() []byte {
+	s := debug.Stack()
+	if len(s) > maxStack {
+		s = s[:maxStack]
+	}
+	return s
+}
+
+func shutdownError() Error {
+	return Error{isShutdown: true, reason: ReasonShutdown, Err: errors.New("circuit has been permanently shutdown. create a new one")}
+}
+
+// fallback calls DefaultFunc for a call that was not run, followed by CleanupFunc unless disabled
+// with WithCleanupOnRejection
+func (b *Breaker) fallback(c *call, commands CommandFuncs) {
+	f := func() {
+		commands.DefaultFunc()
+		if !b.noCleanupOnRejection {
+			commands.CleanupFunc()
+		}
+	}
+	if c.serial == nil {
+		f()
+		return
+	}
+	// A rejection fails fast: the call takes its turn now and its callbacks wait for it on a goroutine.
+	// Counted but never refused, a rejection must not be rejected
+	ticket := c.handOff()
+	atomic.AddInt64(&b.owner().goroutines, 1)
+	b.spawnReserved(func() { c.serial.runAt(ticket, f) })
+}
+
+// minTimeout is the shortest timeout a command can meet, calls with a shorter one always time out
+const minTimeout = time.Millisecond
+
+// run executes an admitted command and waits for it to complete, time out, panic or for ctx to be done.
+// DefaultFunc and CleanupFunc are called on every path but success
+func (b *Breaker) run(ctx context.Context, commands CommandFuncs, c *call, timeout time.Duration) (Outcome, Error) {
+	atomic.AddInt64(&b.running, 1)
+	defer atomic.AddInt64(&b.running, -1)
+	cctx, cancel := context.WithTimeout(context.WithValue(ctx, breakerKey{}, b), timeout)
+	defer cancel()
+	fallback := commands.DefaultFunc
+	if b.speculative {
+		// Fallback runs alongside the command, only waited for if the command fails
+		fallbackDone := make(chan bool)
+		if b.spawn(func() {
+			defer close(fallbackDone)
 			commands.DefaultFunc()
+		}) {
+			fallback = func() { <-fallbackDone }
+		}
+	}
+	// Channels for signalling completion or panic of command
+	sig := newSignals()
+	defer sig.release()
+	done, panicked := sig.done, sig.panicked
+	spawnCommand := b.spawn
+	if c.slot {
+		c.slot = false
+		spawnCommand = func(f func()) bool { b.spawnReserved(f); return true }
+	}
+	if !spawnCommand(func() {
+		sig.started <- struct{}{}
+		defer func() {
+			defer sig.release()
+			if r := recover(); r != nil {
+				panicked <- recovered{value: r, stack: stack()}
+				return
+			}
+			done <- true
+		}()
+		if cc, ok := commands.(ContextCommand); ok {
+			cc.CommandFuncCtx(cctx)
+		} else {
+			commands.CommandFunc()
+		}
+	}) {
+		// Never started, drop the reference of the command goroutine
+		sig.release()
+		c.inOrder(func() {
+			fallback()
 			commands.CleanupFunc()
-			b.openCircuit()
-			errorch <- Error{isSuccess: false, Err: errors.New("reached threshold, cannot run your command")}
+		})
+		b.logEvent(EventRejection, c.fields(nil), "goroutine limit reached")
+		return OutcomeRejected, Error{reason: ReasonSaturated, timeout: timeout, Err: errors.New("reached goroutine limit, cannot run your command")}
+	}
+	var hedge <-chan time.Time
+	var hedged chan bool
+	if b.hedge > 0 && b.hedge < timeout && !b.speculative {
+		hedge = time.After(b.hedge)
+	}
+	// Timer is only armed once the command runs, a short timeout cannot expire before it started
+	<-sig.started
+	expired := time.After(timeout)
+	waitDone, waitPanicked := done, panicked
+	if timeout < minTimeout {
+		// Too short to race the command fairly, the call deterministically times out
+		waitDone, waitPanicked = nil, nil
+	}
+	// Deals with timeout of command
+	for {
+		select {
+		case <-hedge:
+			// Command is slow, hedge with the fallback and keep waiting for either
+			hedge = nil
+			hedged = make(chan bool)
+			if !b.spawn(func() {
+				defer close(hedged)
+				commands.DefaultFunc()
+			}) {
+				// No slot left to hedge, keep waiting for the command alone
+				hedged = nil
+				continue
+			}
+			fallback = func() { <-hedged }
+		case <-hedged:
+			// Fallback won, the command is abandoned
+			cancel()
+			c.inOrder(commands.CleanupFunc)
+			b.logEvent(EventTimeout, c.fields(logrus.Fields{"hedge": b.hedge}), "fallback finished before the task")
+			return OutcomeHedged, Error{reason: ReasonHedged, timeout: timeout, Err: errors.New("fallback finished before the task")}
+		case <-ctx.Done():
+			c.inOrder(func() {
+				fallback()
+				commands.CleanupFunc()
+			})
+			outcome := b.classify(ctx.Err())
+			b.logEvent(EventCanceled, c.fields(logrus.Fields{"outcome": outcome}), "task context done")
+			return outcome, Error{isTimeout: outcome == OutcomeTimeout, reason: ReasonCanceled, timeout: timeout, Err: ctx.Err()}
+		case <-expired:
+			// Call default and cleanup
+			c.inOrder(func() {
+				fallback()
+				commands.CleanupFunc()
+			})
+			b.logEvent(EventTimeout, c.fields(logrus.Fields{"timeout": timeout}), "task timed out")
+			if b.abandon != nil {
+				b.abandon(commands)
+			}
+			// Return timeout error
+			return OutcomeTimeout, Error{isTimeout: true, reason: ReasonTimeout, timeout: timeout, Err: errors.New("task timed out")}
+		case p := <-waitPanicked:
+			r := p.value
+			c.inOrder(func() {
+				fallback()
+				commands.CleanupFunc()
+			})
+			b.logEvent(EventPanic, c.fields(logrus.Fields{"panic": r, "stack": string(p.stack)}), "task panicked")
+			if b.propagatePanics {
+				panic(r)
+			}
+			return OutcomePanic, Error{isPanic: true, reason: ReasonPanic, timeout: timeout, stack: string(p.stack), recovered: r, Err: errors.Errorf("task panicked: %v", r)}
+		case <-waitDone:
+			if _, ok := commands.(ContextCommand); ok && cctx.Err() != nil {
+				// Command returned because it honoured the cancellation or the deadline, the call was
+				// canceled or timed out
+				waitDone = nil
+				continue
+			}
+			if err := failure(commands); err != nil {
+				c.inOrder(func() {
+					fallback()
+					commands.CleanupFunc()
+				})
+				b.logEvent(EventFailure, c.fields(logrus.Fields{"error": err}), "task failed")
+				return OutcomeFailure, Error{reason: ReasonFailed, timeout: timeout, Err: err}
+			}
+			b.logEvent(EventSuccess, c.fields(nil), "task succeeded")
+			return OutcomeSuccess, Error{isSuccess: true, timeout: timeout, Err: nil}
+		}
+	}
+}
+
+// finish records the outcome of a call and hands the result to the client, called exactly once per call
+func (b *Breaker) finish(deliver func(Result, Error), commands CommandFuncs, c *call, outcome Outcome, submitted time.Time, be Error) {
+	if c.ticketed {
+		// No callbacks were called, later calls need not wait
+		c.serial.done(c.ticket)
+		c.ticketed = false
+	}
+	be.queue, be.service, be.id = c.queue, c.service, c.id
+	be.name, be.time = b.name, b.clock.Now()
+	var d time.Duration
+	if c.observers == nil {
+		d = b.observe(c.name, outcome, submitted, be, c.bypass)
+	}
+	for _, o := range c.observers {
+		d = o.observe(o.commandName(commands), outcome, submitted, be, c.bypass)
+	}
+	r := Result{Outcome: outcome, Duration: d, Labels: c.labels, CallID: c.id}
+	if outcome != OutcomeSuccess {
+		r.Err = be
+	}
+	deliver(r, be)
+}
+
+// observe feeds the outcome of a call to the statistics, the trip policy and OnComplete
+func (b *Breaker) observe(name string, outcome Outcome, submitted time.Time, be Error, bypass bool) time.Duration {
+	d := b.since(submitted)
+	b.latencies[outcome].record(d)
+	if outcome == OutcomeRejected {
+		switch be.reason {
+		case ReasonOpen:
+			atomic.AddInt64(&b.rejectedOpen, 1)
+		case ReasonSaturated:
+			atomic.AddInt64(&b.rejectedSaturated, 1)
+		}
+	}
+	if outcome == OutcomeSuccess {
+		b.observeLatency(d)
+	}
+	if !bypass && be.reason != ReasonDenied {
+		failed, changed := b.record(outcome, be)
+		b.remember(be.id, outcome, d, failed, changed && b.State() == StateOpen)
+		for p := b.parent; p != nil && !b.isKey; p = p.parent {
+			p.record(outcome, be)
+		}
+	}
+	b.mu.Lock()
+	f := b.onComplete
+	b.mu.Unlock()
+	if f != nil {
+		f(name, outcome, d)
+	}
+	return d
+}
+
+// recordWait accounts for the time an admitted call waited for its tokens
+func (b *Breaker) recordWait(d time.Duration) {
+	b.waits.record(d)
+	atomic.AddInt64(&b.waitCount, 1)
+	atomic.AddInt64(&b.waitTotal, int64(d))
+	b.mu.Lock()
+	f := b.onAcquire
+	b.mu.Unlock()
+	if f != nil {
+		f(d)
+	}
+}
+
+// OnAcquire registers a callback invoked with the time every admitted call waited for admission,
+// queueing latency as opposed to command latency. The callback is invoked outside of any lock
+func (b *Breaker) OnAcquire(f func(d time.Duration)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onAcquire = f
+}
+
+// OnComplete registers a callback invoked once for every call to Execute after it terminates, whatever
+// the outcome. The duration is measured from submission. The callback is invoked outside of any lock
+func (b *Breaker) OnComplete(f func(name string, outcome Outcome, d time.Duration)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onComplete = f
+}
+
+// admit obtains admission for a call and returns the function releasing it. If the breaker itself
+// fails, the fail mode decides whether the call runs unprotected or is rejected
+func (b *Breaker) admit(ctx context.Context, c *call) (release func(), err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logEvent(EventInternal, logrus.Fields{"panic": r}, "internal breaker error")
+			if b.failMode == FailOpen {
+				release, err = func() {}, nil
+				return
+			}
+			release, err = nil, errors.Errorf("internal breaker error: %v", r)
 		}
 	}()
-	return errorch
+	if err := b.acquire(ctx, c); err != nil {
+		return nil, err
+	}
+	return func() { b.release(c) }, nil
+}
+
+// acquire obtains admission from the parent breakers first and then from this breaker.
+// Saturation trips the breaker that ran out of capacity
+func (b *Breaker) acquire(ctx context.Context, c *call) error {
+	if b.parent != nil {
+		if err := b.parent.acquire(ctx, c); err != nil {
+			return err
+		}
+	}
+	if b.numConcurrent > 0 && c.weight > b.numConcurrent {
+		if b.parent != nil {
+			b.parent.release(c)
+		}
+		return reject(ReasonInvalid, errors.Errorf("weight %d exceeds capacity %d, cannot run your command", c.weight, b.numConcurrent))
+	}
+	var err error
+	if !c.bypass {
+		// A bypass call ignores the state of the circuit, not its capacity
+		err = b.admitState(c)
+	}
+	if err != nil {
+		if b.dryRun && reasonOf(err) == ReasonOpen {
+			b.wouldHaveRejected(c, err)
+		} else {
+			if b.parent != nil {
+				b.parent.release(c)
+			}
+			return err
+		}
+	}
+	for i := 0; i < c.weight; i++ {
+		if !b.limiter.Acquire(ctx) {
+			for ; i > 0; i-- {
+				b.limiter.Release()
+			}
+			if ctx.Err() != context.Canceled {
+				// A waiter canceled by its client says nothing about capacity, one that ran out of time does
+				b.apply(event{kind: eventSaturated})
+			}
+			if b.dryRun && ctx.Err() == nil {
+				// Runs without tokens of this breaker, release must not return them
+				c.untokened = append(c.untokened, b)
+				b.wouldHaveRejected(c, errors.New("reached threshold"))
+				return nil
+			}
+			if b.parent != nil {
+				b.parent.release(c)
+			}
+			if err := ctx.Err(); err != nil {
+				// Left the queue, the context decides the outcome as it does for a running call
+				return err
+			}
+			return reject(ReasonSaturated, errors.New("reached threshold, cannot run your command"))
+		}
+	}
+	return nil
+}
+
+// wouldHaveRejected accounts for a call admitted only because of WithDryRun
+func (b *Breaker) wouldHaveRejected(c *call, err error) {
+	atomic.AddInt64(&b.wouldReject, 1)
+	b.logEvent(EventRejection, c.fields(logrus.Fields{"error": err}), "task would be rejected, admitted in dry run")
+}
+
+// release returns tokens in the reverse order of acquire
+func (b *Breaker) release(c *call) {
+	if c.holdsTokens(b) {
+		for i := 0; i < c.weight; i++ {
+			b.limiter.Release()
+		}
+	}
+	if b.parent != nil {
+		b.parent.release(c)
+	}
+}
+
+// classify decides the outcome of a call whose context is done
+func (b *Breaker) classify(err error) Outcome {
+	if b.classifier != nil {
+		return b.classifier(err)
+	}
+	return DefaultClassifier(err)
+}
+
+// DefaultClassifier ignores calls canceled by the client, anything else counts as a timeout
+func DefaultClassifier(err error) Outcome {
+	if errors.Is(err, context.Canceled) {
+		return OutcomeIgnored
+	}
+	return OutcomeTimeout
+}
+
+// owner returns the breaker whose goroutine and queue caps bound the calls of b, the parent of a key
+func (b *Breaker) owner() *Breaker {
+	if b.isKey {
+		return b.parent
+	}
+	return b
+}
+
+// reserve takes n goroutine slots, false if that would exceed WithMaxGoroutines
+func (b *Breaker) reserve(n int64) bool {
+	o := b.owner()
+	for {
+		live := atomic.LoadInt64(&o.goroutines)
+		if o.maxGoroutines > 0 && live+n > o.maxGoroutines {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&o.goroutines, live, live+n) {
+			return true
+		}
+	}
+}
+
+// unreserve gives back n goroutine slots taken by reserve
+func (b *Breaker) unreserve(n int64) {
+	atomic.AddInt64(&b.owner().goroutines, -n)
+}
+
+// spawn runs f in a new goroutine, keeping count of live goroutines. Returns false without running f
+// when no slot is left
+func (b *Breaker) spawn(f func()) bool {
+	if !b.reserve(1) {
+		return false
+	}
+	b.spawnReserved(f)
+	return true
+}
+
+// spawnReserved runs f in a new goroutine on a slot already taken by reserve
+func (b *Breaker) spawnReserved(f func()) {
+	go func() {
+		defer b.unreserve(1)
+		f()
+	}()
+}
+
+// releaseSlot gives back the slot reserved for the command of a call that never ran
+func (b *Breaker) releaseSlot(c *call) {
+	if c.slot {
+		c.slot = false
+		b.unreserve(1)
+	}
+}
+
+// failure returns the error of a command that failed without panicking
+func failure(commands CommandFuncs) error {
+	switch f := commands.(type) {
+	case failer:
+		return f.failure()
+	case Failer:
+		return f.Failure()
+	}
+	return nil
 }
 
 func (b *Breaker) commandTimeout(c CommandFuncs) time.Duration {
-	if t, ok := c.(Timeout); ok {
-		return t.timeout()
+	if t, ok := c.(Timeout); ok && t.Timeout() > 0 {
+		return t.Timeout()
 	}
-	return b.timeout
+	return b.currentTimeout()
+}
+
+// Result of a call, Err is nil only for a successful call
+type Result struct {
+	Outcome  Outcome
+	Err      error
+	Duration time.Duration     // Measured from submission
+	Labels   map[string]string // Set with WithLabels
+	CallID   uint64            // See Breaker.Amend
 }
 
 // Error can be unwrappd by clients to determine exact nature of failure
@@ -173,6 +1071,17 @@ type Error struct {
 	isTimeout  bool
 	isShutdown bool
 	isSuccess  bool
+	isPanic    bool
+	reason     Reason
+	timeout    time.Duration
+	stack      string // Kept as a string so that Error stays comparable
+	recovered  any
+	retryAfter time.Duration
+	queue      time.Duration
+	service    time.Duration
+	id         uint64
+	name       string
+	time       time.Time
 }
 
 func (b Error) Unwrap() error  { return b.Err }
@@ -180,3 +1089,61 @@ func (b Error) Error() string  { return b.Err.Error() }
 func (b Error) Timeout() bool  { return b.isTimeout }
 func (b Error) Success() bool  { return b.isSuccess }
 func (b Error) Shutdown() bool { return b.isShutdown }
+func (b Error) Panic() bool    { return b.isPanic }
+
+// Reason tells why the call did not succeed, ReasonNone for a success
+func (b Error) Reason() Reason { return b.reason }
+
+// Equal reports whether both Errors tell the same outcome, comparing reasons and flags. The wrapped
+// errors, created anew for every call, are not compared
+func (b Error) Equal(other Error) bool {
+	return b.reason == other.reason && b.isTimeout == other.isTimeout && b.isShutdown == other.isShutdown &&
+		b.isSuccess == other.isSuccess && b.isPanic == other.isPanic
+}
+
+// ShouldRetry tells whether the call is worth retrying with backoff: true when the breaker was
+// saturated or the command timed out. False when the circuit is open, wait for RetryAfter instead,
+// and when it was shut down, never retry
+func (b Error) ShouldRetry() bool {
+	return b.reason == ReasonSaturated || b.reason == ReasonTimeout
+}
+
+// RetryAfter is how long to wait before retrying a call rejected by an open circuit, see
+// Breaker.RetryAfter. Zero for other outcomes
+func (b Error) RetryAfter() time.Duration { return b.retryAfter }
+
+// Stack is the stack of the goroutine of a panicked command, captured when the panic was recovered
+// and truncated to 8KB. Nil for other outcomes
+func (b Error) Stack() []byte {
+	if b.stack == "" {
+		return nil
+	}
+	return []byte(b.stack)
+}
+
+// RecoveredValue is the value a panicked command passed to panic, to inspect typed panics or panic
+// again. Nil for other outcomes
+func (b Error) RecoveredValue() any { return b.recovered }
+
+// EffectiveTimeout is the timeout that applied to the command, after WithCallTimeout, the Timeout
+// interface, WithAdaptiveTimeout and WithTotalBudget were taken into account. Zero if it never ran
+func (b Error) EffectiveTimeout() time.Duration { return b.timeout }
+
+// CallID identifies the call to Breaker.Amend, zero for a call that was never submitted
+func (b Error) CallID() uint64 { return b.id }
+
+// QueueDuration is the time from submission to admission or rejection, slow to start. Zero for calls
+// rejected before queueing, such as by a shut down breaker
+func (b Error) QueueDuration() time.Duration { return b.queue }
+
+// ServiceDuration is the time the command ran until its result, slow to run. For a timeout it is
+// the time until the call gave up on the command. Zero if it never ran
+func (b Error) ServiceDuration() time.Duration { return b.service }
+
+// Name is the name of the breaker the call went through, to tell apart errors of many breakers
+// funnelled into one log. Empty for a breaker not created with New
+func (b Error) Name() string { return b.name }
+
+// Time is when the call ended, read from the clock of the breaker. Zero for a breaker not created
+// with New
+func (b Error) Time() time.Time { return b.time }
@@ -8,6 +8,13 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// log is the logger of the test commands, shared so it is configured once
+var log = func() *logrus.Logger {
+	l := initLog()
+	l.Formatter = new(logrus.JSONFormatter)
+	return l
+}()
+
 type wrapperE1 struct {
 	state string
 }
@@ -17,7 +24,6 @@ func (w *wrapperE1) CommandFunc() {
 	fmt.Println("Executing ", w.state)
 }
 func (w *wrapperE1) DefaultFunc() {
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Defaulted": w.state}).Info("task was defaulted")
 	//fmt.Println("Defaulting command.....")
 }
@@ -25,7 +31,7 @@ func (w *wrapperE1) CleanupFunc() {
 	log.WithFields(logrus.Fields{"Cleaned": w.state}).Info("task was cleaned")
 	//fmt.Println("Canceling command.....")
 }
-func (w *wrapperE1) timeout() time.Duration {
+func (w *wrapperE1) Timeout() time.Duration {
 	return 200 * time.Millisecond
 }
 func (w *wrapperE1) Name() string {
@@ -38,18 +44,15 @@ type wrapperE2 struct {
 
 func (w *wrapperE2) CommandFunc() {
 	time.Sleep(100 * time.Millisecond)
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Executed": w.state}).Info("task was executed")
 }
 func (w *wrapperE2) DefaultFunc() {
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Defaulted": w.state}).Info("task was defaulted")
 }
 func (w *wrapperE2) CleanupFunc() {
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Cleaned": w.state}).Info("task was cleaned")
 }
-func (w *wrapperE2) timeout() time.Duration {
+func (w *wrapperE2) Timeout() time.Duration {
 	return 50 * time.Millisecond
 }
 func (w *wrapperE2) Name() string {
@@ -68,7 +71,6 @@ func (w *wrapperE3) CommandFunc() {
 			return
 		}
 	}
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Executed": w.state}).Info("task was executed")
 }
 func (w *wrapperE3) DefaultFunc() {
@@ -1,6 +1,8 @@
 package breaker
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,14 +15,12 @@ type wrapper struct {
 
 func (w *wrapper) CommandFunc() {
 	var log = logrus.New()
-	log.Formatter = new(logrus.JSONFormatter)
 	time.Sleep(1 * time.Millisecond)
 	log.WithFields(logrus.Fields{"Executed": w.state}).Info("task was executed")
 	//fmt.Println("Executing ", w.name)
 	w.exec = true
 }
 func (w *wrapper) DefaultFunc() {
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Defaulted": w.state}).Info("task was defaulted")
 	//fmt.Println("Defaulting command.....")
 }
@@ -41,17 +41,14 @@ type wrapper2 struct {
 func (w *wrapper2) CommandFunc() {
 	time.Sleep(1000 * time.Millisecond)
 	//fmt.Println("Executing ", w.name)
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Executed": w.name}).Info("task was executed")
 	w.exec = true
 }
 func (w *wrapper2) DefaultFunc() {
 	//fmt.Println("Defaulting ", w.name)
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Defaulted": w.name}).Info("task was defaulted")
 }
 func (w *wrapper2) CleanupFunc() {
-	log.Formatter = new(logrus.JSONFormatter)
 	log.WithFields(logrus.Fields{"Cleaned": w.name}).Info("task was cleaned")
 	//fmt.Println("Cleaning ", w.name)
 }
@@ -69,9 +66,82 @@ func (w *wrapper3) DefaultFunc() {
 }
 func (w *wrapper3) CleanupFunc() {
 }
-func (w *wrapper3) timeout() time.Duration {
+func (w *wrapper3) Timeout() time.Duration {
 	return time.Millisecond
 }
 func (w *wrapper3) Name() string {
 	return "task1"
 }
+
+// fakeClock is a clock controlled by tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// blocker runs until release is closed
+type blocker struct {
+	release chan bool
+}
+
+func (w *blocker) CommandFunc() { <-w.release }
+func (w *blocker) DefaultFunc() {}
+func (w *blocker) CleanupFunc() {}
+func (w *blocker) Name() string { return "blocker" }
+
+// panicker panics when executed
+type panicker struct {
+	defaulted bool
+	cleaned   bool
+}
+
+func (w *panicker) CommandFunc() { panic("command is broken") }
+func (w *panicker) DefaultFunc() { w.defaulted = true }
+func (w *panicker) CleanupFunc() { w.cleaned = true }
+func (w *panicker) Name() string { return "panicker" }
+
+// slowFallback blocks until release is closed and takes d to default
+type slowFallback struct {
+	release chan bool
+	d       time.Duration
+}
+
+func (w *slowFallback) CommandFunc() { <-w.release }
+func (w *slowFallback) DefaultFunc() { time.Sleep(w.d) }
+func (w *slowFallback) CleanupFunc() {}
+func (w *slowFallback) Name() string { return "slowFallback" }
+
+// counter counts calls to DefaultFunc and CleanupFunc
+type counter struct {
+	defaults int32
+	cleanups int32
+}
+
+func (w *counter) CommandFunc() {}
+func (w *counter) DefaultFunc() { atomic.AddInt32(&w.defaults, 1) }
+func (w *counter) CleanupFunc() { atomic.AddInt32(&w.cleanups, 1) }
+func (w *counter) Name() string { return "counter" }
+
+// running records that its command ran
+type running struct {
+	*counter
+	ran *int32
+}
+
+func (w *running) CommandFunc() { atomic.StoreInt32(w.ran, 1) }
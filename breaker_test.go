@@ -1,12 +1,16 @@
 package breaker
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -34,7 +38,7 @@ func Test_Shutdown(t *testing.T) {
 	fmt.Println("Testing Test_Shutdown")
 	b := New("name", time.Second, 0)
 	b.isOk = false
-	b.HealthCheckInterval = 5
+	b.SetHealthCheckInterval(5 * time.Millisecond)
 	b.Shutdown()
 	if b.status != iShutdown {
 		t.Errorf("Shutdown should have initiated")
@@ -42,9 +46,9 @@ func Test_Shutdown(t *testing.T) {
 }
 
 func Test_scanner_circuit_repaired(t *testing.T) {
-	b := New("name", time.Second, 1)
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithRecoveryPolicy(CapacityAvailable))
 	b.isOk = false
-	b.HealthCheckInterval = 10
+	b.SetHealthCheckInterval(10 * time.Millisecond)
 	fmt.Println("starting Test_scanner_circuit_repaired")
 	time.Sleep(150 * time.Millisecond)
 	fmt.Println("Return = ", b.status)
@@ -58,7 +62,7 @@ func Test_scanner_circuit_repaired(t *testing.T) {
 func Test_Execute_t(t *testing.T) {
 	commands := &wrapper{}
 	b := New("name", 10*time.Millisecond, 3)
-	b.HealthCheckInterval = 1000
+	b.SetHealthCheckInterval(1000 * time.Millisecond)
 	var wg sync.WaitGroup
 	wg.Add(5)
 	for i := 0; i < 5; i++ {
@@ -103,8 +107,8 @@ func Test_Execute_2(t *testing.T) {
 
 func Test_Execute_exceed_limit_wait_till_circuit_ok(t *testing.T) {
 	fmt.Println("Running Test_Execute_exceed_limit_wait_till_circuit_ok demo....")
-	b := New("name", 2000*time.Millisecond, 3)
-	b.HealthCheckInterval = 1000
+	b := NewWithOptions("name", WithTimeout(2000*time.Millisecond), WithConcurrency(3), WithRecoveryPolicy(CapacityAvailable))
+	b.SetHealthCheckInterval(1000 * time.Millisecond)
 	w1 := &wrapper2{"Service 1", false}
 	b.Execute(w1)
 	w2 := &wrapper2{"Service 2", false}
@@ -125,8 +129,8 @@ func Test_Execute_exceed_limit_wait_till_circuit_ok(t *testing.T) {
 
 func Test_execute_exceed_limit_wait_tillok_submit_more(t *testing.T) {
 	fmt.Println("Running Test_execute_exceed_limit_wait_tillok_submit_more demo....")
-	b := New("name", 10*time.Millisecond, 3)
-	b.HealthCheckInterval = 1000
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(3), WithRecoveryPolicy(CapacityAvailable))
+	b.SetHealthCheckInterval(1000 * time.Millisecond)
 	w1 := &wrapper2{"Service 1", false}
 	b.Execute(w1)
 	w2 := &wrapper2{"Service 2", false}
@@ -145,9 +149,9 @@ func Test_execute_exceed_limit_wait_tillok_submit_more(t *testing.T) {
 	b.Shutdown()
 }
 func Test_scanner_circuit_multipl_Shutdown(t *testing.T) {
-	b := New("name", time.Second, 1)
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithRecoveryPolicy(CapacityAvailable))
 	b.isOk = false
-	b.HealthCheckInterval = 10
+	b.SetHealthCheckInterval(10 * time.Millisecond)
 	fmt.Println("starting Test_scanner_circuit_multipl_Shutdown")
 	time.Sleep(15 * time.Millisecond)
 	fmt.Println("Return = ", b.status)
@@ -171,6 +175,39 @@ func Test_timeout_default(t *testing.T) {
 	}
 }
 
+func Test_effective_timeout(t *testing.T) {
+	b := New("name", 5*time.Millisecond, 2)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	if err := <-b.Execute(w); !err.Timeout() || err.EffectiveTimeout() != 5*time.Millisecond {
+		t.Errorf("Was expecting the breaker timeout, instead got %v", err.EffectiveTimeout())
+	}
+	if err := <-b.Execute(&wrapper3{}); err.EffectiveTimeout() != time.Millisecond {
+		t.Errorf("Was expecting the command timeout, instead got %v", err.EffectiveTimeout())
+	}
+	if err := <-b.Execute(w, WithCallTimeout(2*time.Millisecond)); !err.Timeout() || err.EffectiveTimeout() != 2*time.Millisecond {
+		t.Errorf("Was expecting the call timeout, instead got %v", err.EffectiveTimeout())
+	}
+}
+
+func Test_error_name_and_time(t *testing.T) {
+	c := newFakeClock()
+	b := NewWithOptions("payments", WithTimeout(5*time.Millisecond), WithConcurrency(1), withClock(c))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	err := <-b.Execute(w)
+	if !err.Timeout() || err.Name() != "payments" {
+		t.Errorf("Was expecting a timeout of payments, instead got %v of %q", err, err.Name())
+	}
+	if !err.Time().Equal(c.Now()) {
+		t.Errorf("Was expecting %v, instead got %v", c.Now(), err.Time())
+	}
+}
+
 func Test_exeute_after_shutdown(t *testing.T) {
 	fmt.Println("Running Test_exeute_after_shutdown demo....")
 	b := New("name", 10*time.Millisecond, 3)
@@ -179,8 +216,8 @@ func Test_exeute_after_shutdown(t *testing.T) {
 	ch := b.Execute(w1)
 	err := <-ch
 	fmt.Println(err)
-	if !strings.Contains(err.Error(), "cicuit has been permanently shutdown") {
-		t.Errorf("Should contain %s %s'", "cicuit has been permanently shutdown", "'")
+	if !strings.Contains(err.Error(), "circuit has been permanently shutdown") {
+		t.Errorf("Should contain %s %s'", "circuit has been permanently shutdown", "'")
 	}
 }
 
@@ -259,7 +296,7 @@ func TestBreaker(t *testing.T) {
 	fmt.Println("Running Test_execute_exceed_limit_wait_till_circuit_ok demo....")
 	//b := &breaker.Breaker{}
 	b := New("name", 1010*time.Millisecond, 5)
-	b.HealthCheckInterval = 1000
+	b.SetHealthCheckInterval(1000 * time.Millisecond)
 	w1 := &wrapper2{"Service 1", false}
 	b.Execute(w1)
 	w2 := &wrapper2{"Service 2", false}
@@ -303,3 +340,393 @@ func (w *wrapper21) CleanupFunc() {
 		fmt.Println("Failure: Cleaning ", w.name)
 	}
 }
+
+func Test_trigger_healthcheck_repairs_circuit(t *testing.T) {
+	recorder := &EventRecorder{}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithEventRecorder(recorder))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	if b.isOk {
+		t.Errorf("Circuit should have been open")
+	}
+	b.triggerHealthCheck()
+	if b.State() != StateHalfOpen {
+		t.Errorf("Triggered probe should have moved circuit to half open, instead got %v", b.State())
+	}
+	if err := <-b.Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Trial should have been admitted, instead got %v", err)
+	}
+	if !b.isOk || b.status != iCircuitGood {
+		t.Errorf("Circuit should have been repaired by the successful trial")
+	}
+	var got []string
+	for _, e := range recorder.Drain() {
+		if e.Type == EventTransition {
+			got = append(got, fmt.Sprint(e.Fields["to"]))
+		} else if e.Type == EventSuccess {
+			got = append(got, "success")
+		}
+	}
+	want := []string{"open", "half-open", "success", "closed"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Was expecting events %v, instead got %v", want, got)
+	}
+	if events := recorder.Drain(); len(events) != 0 {
+		t.Errorf("Drain should forget events, instead got %v", events)
+	}
+}
+
+func Test_failed_trial_reopens(t *testing.T) {
+	b := New("name", 10*time.Millisecond, 2)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	b.triggerHealthCheck()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	ch := b.Execute(w)
+	waitFor(func() bool { return b.limiter.InFlight() == 1 })
+	if err := <-b.Execute(&wrapper3{}); err.Success() {
+		t.Errorf("Only one trial should be admitted while half open")
+	}
+	if err := <-ch; !err.Timeout() {
+		t.Errorf("Trial should have timed out, instead got %v", err)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Failed trial should reopen the circuit, instead got %v", b.State())
+	}
+}
+
+func Test_custom_recovery_policy(t *testing.T) {
+	var healthy int32
+	policy := func(b *Breaker) bool { return atomic.LoadInt32(&healthy) == 1 }
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithRecoveryPolicy(policy))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	b.triggerHealthCheck()
+	if b.State() != StateOpen {
+		t.Errorf("Policy should keep circuit open, instead got %v", b.State())
+	}
+	atomic.StoreInt32(&healthy, 1)
+	b.triggerHealthCheck()
+	if b.State() != StateClosed {
+		t.Errorf("Policy should close circuit, instead got %v", b.State())
+	}
+}
+
+func Test_warmup_does_not_trip(t *testing.T) {
+	c := newFakeClock()
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(0), WithWarmup(time.Minute), withClock(c))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	err := <-b.Execute(&wrapper3{})
+	if err.Success() {
+		t.Errorf("Concurrency limit should still apply during warmup")
+	}
+	if !b.isOk {
+		t.Errorf("Circuit should not trip during warmup")
+	}
+	c.Add(time.Minute)
+	<-b.Execute(&wrapper3{})
+	if b.isOk {
+		t.Errorf("Circuit should trip after warmup")
+	}
+}
+
+func Test_on_complete_once_per_call(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	var mu sync.Mutex
+	outcomes := map[Outcome]int{}
+	b.OnComplete(func(name string, outcome Outcome, d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if d < 0 {
+			t.Errorf("Duration should not be negative, got %v", d)
+		}
+		outcomes[outcome]++
+	})
+	<-b.Execute(&wrapper3{})
+	w := &blocker{release: make(chan bool)}
+	<-b.Execute(w)
+	close(w.release)
+	b.Shutdown()
+	<-b.Execute(&wrapper3{})
+	mu.Lock()
+	defer mu.Unlock()
+	if outcomes[OutcomeSuccess] != 1 || outcomes[OutcomeTimeout] != 1 || outcomes[OutcomeRejected] != 1 {
+		t.Errorf("Was expecting one callback per outcome, instead got %v", outcomes)
+	}
+}
+
+func Test_panic_is_not_success(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var got Outcome = -1
+	b.OnComplete(func(name string, outcome Outcome, d time.Duration) { got = outcome })
+	w := &panicker{}
+	err := <-b.Execute(w)
+	if err.Success() || !err.Panic() {
+		t.Errorf("Was expecting a panic, instead got %v", err)
+	}
+	if got != OutcomePanic {
+		t.Errorf("Was expecting %v, instead got %v", OutcomePanic, got)
+	}
+	if !w.defaulted || !w.cleaned {
+		t.Errorf("DefaultFunc and CleanupFunc should run after a panic")
+	}
+}
+
+func Test_panic_stack(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	err := <-b.Execute(&panicker{})
+	if s := err.Stack(); len(s) == 0 || len(s) > maxStack || !strings.Contains(string(s), "panicker") {
+		t.Errorf("Was expecting the stack of the panicking command, instead got %q", s)
+	}
+	if s := (<-b.Execute(&wrapper3{})).Stack(); s != nil {
+		t.Errorf("Was expecting no stack without a panic, instead got %q", s)
+	}
+}
+
+func Test_error_comparable(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	err := <-b.Execute(&panicker{})
+	seen := map[Error]bool{err: true}
+	if !seen[err] {
+		t.Errorf("Was expecting a panicked Error to be usable as a map key")
+	}
+}
+
+// typedPanic panics with a value of a custom type
+type typedPanic struct {
+	*counter
+}
+
+type panicCode struct{ code int }
+
+func (w *typedPanic) CommandFunc() { panic(panicCode{code: 42}) }
+
+func Test_panic_recovered_value(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	err := <-b.Execute(&typedPanic{counter: &counter{}})
+	if v, ok := err.RecoveredValue().(panicCode); !ok || v.code != 42 {
+		t.Errorf("Was expecting panicCode 42, instead got %#v", err.RecoveredValue())
+	}
+	if v := (<-b.Execute(&counter{})).RecoveredValue(); v != nil {
+		t.Errorf("Was expecting no value without a panic, instead got %v", v)
+	}
+}
+
+func Test_execute_uninitialized(t *testing.T) {
+	var nilBreaker *Breaker
+	for _, b := range []*Breaker{{}, nilBreaker} {
+		err := <-b.Execute(&wrapper3{})
+		if !errors.Is(err, ErrNotInitialized) {
+			t.Errorf("Was expecting ErrNotInitialized, instead got %v", err)
+		}
+	}
+}
+
+func Test_lifecycle_uninitialized(t *testing.T) {
+	var nilBreaker *Breaker
+	for _, b := range []*Breaker{{}, nilBreaker} {
+		if err := b.Close(); !errors.Is(err, ErrNotInitialized) {
+			t.Errorf("Was expecting ErrNotInitialized from Close, instead got %v", err)
+		}
+		if b.Shutdown() {
+			t.Errorf("Was expecting Shutdown to report nothing shut down")
+		}
+		if s := b.Stats(); s.Goroutines != 0 || s.Count(OutcomeSuccess) != 0 {
+			t.Errorf("Was expecting empty Stats, instead got %+v", s)
+		}
+	}
+}
+
+func Test_speculative_fallback(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(60*time.Millisecond), WithConcurrency(1), WithSpeculativeFallback())
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &slowFallback{release: make(chan bool), d: 50 * time.Millisecond}
+	defer close(w.release)
+	start := time.Now()
+	if err := <-b.Execute(w); !err.Timeout() {
+		t.Errorf("Was expecting a timeout, instead got %v", err)
+	}
+	// Without speculation the fallback would only start at 60ms and end at 110ms
+	if elapsed := time.Since(start); elapsed > 90*time.Millisecond {
+		t.Errorf("Fallback should have been ready at timeout, took %v", elapsed)
+	}
+}
+
+func Test_panic_propagation(t *testing.T) {
+	if os.Getenv("BREAKER_PROPAGATE") == "1" {
+		b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithPanicPropagation(true))
+		<-b.Execute(&panicker{})
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_panic_propagation$")
+	cmd.Env = append(os.Environ(), "BREAKER_PROPAGATE=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "command is broken") {
+		t.Errorf("Was expecting the process to crash with the panic, instead got %v %s", err, out)
+	}
+}
+
+// sometimesSlow is slow on one call out of ten, its fallback is immediate
+type sometimesSlow struct {
+	calls int32
+}
+
+func (w *sometimesSlow) CommandFunc() {
+	if atomic.AddInt32(&w.calls, 1)%10 == 1 {
+		time.Sleep(40 * time.Millisecond)
+	}
+}
+func (w *sometimesSlow) DefaultFunc() {}
+func (w *sometimesSlow) CleanupFunc() {}
+func (w *sometimesSlow) Name() string { return "sometimesSlow" }
+
+func Test_hedge_cuts_tail_latency(t *testing.T) {
+	slowest := func(opts ...Option) (time.Duration, map[Outcome]int) {
+		b := NewWithOptions("name", append([]Option{WithTimeout(time.Second), WithConcurrency(1)}, opts...)...)
+		b.SetHealthCheckInterval(100000 * time.Millisecond)
+		defer b.Shutdown()
+		w := &sometimesSlow{}
+		var max time.Duration
+		outcomes := make(map[Outcome]int)
+		for i := 0; i < 20; i++ {
+			r := <-b.ExecuteResult(context.Background(), w)
+			outcomes[r.Outcome]++
+			if r.Duration > max {
+				max = r.Duration
+			}
+			// Slow command still holds its token after a hedge
+			waitFor(func() bool { return b.Running() == 0 && b.limiter.InFlight() == 0 })
+		}
+		return max, outcomes
+	}
+	unhedged, _ := slowest()
+	hedged, outcomes := slowest(WithHedge(5 * time.Millisecond))
+	if unhedged < 40*time.Millisecond || hedged > 30*time.Millisecond {
+		t.Errorf("Was expecting the hedge to cut p99, instead got %v hedged and %v unhedged", hedged, unhedged)
+	}
+	if outcomes[OutcomeHedged] != 2 || outcomes[OutcomeSuccess] != 18 {
+		t.Errorf("Was expecting 2 hedged and 18 successful calls, instead got %v", outcomes)
+	}
+}
+
+func Test_short_timeout_always_expires(t *testing.T) {
+	b := New("name", time.Microsecond, 10)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	for i := 0; i < 50; i++ {
+		w := &counter{}
+		if err := <-b.Execute(w); !err.Timeout() {
+			t.Fatalf("Was expecting a 1µs timeout to always expire, instead got %v on call %d", err, i)
+		}
+		if atomic.LoadInt32(&w.defaults) != 1 {
+			t.Errorf("Was expecting the fallback of the timed out call")
+		}
+		b.apply(event{kind: eventProbe})
+	}
+	if _, err := NewChecked("name", WithTimeout(time.Microsecond), WithConcurrency(1)); err == nil {
+		t.Errorf("Was expecting NewChecked to reject a 1µs timeout")
+	}
+}
+
+// named is running under another name
+type named struct {
+	running
+	name string
+}
+
+func (w *named) Name() string { return w.name }
+
+func Test_name_validator(t *testing.T) {
+	validate := func(name string) string {
+		if strings.HasPrefix(name, "bad") {
+			return ""
+		}
+		if len(name) > 4 {
+			return name[:4]
+		}
+		return name
+	}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithNameValidator(validate))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var names []string
+	b.OnComplete(func(name string, outcome Outcome, d time.Duration) { names = append(names, name) })
+	if err := <-b.Execute(&sleeper{}); !err.Success() {
+		t.Errorf("Was expecting success, instead got %v", err)
+	}
+	if len(names) != 1 || names[0] != "slee" {
+		t.Errorf("Was expecting the truncated name, instead got %v", names)
+	}
+	var ran int32
+	bad := &named{running: running{counter: &counter{}, ran: &ran}, name: "bad-123"}
+	if err := <-b.Execute(bad); err.Reason() != ReasonInvalid || ran != 0 {
+		t.Errorf("Was expecting the bad name to be rejected without running, instead got %v", err)
+	}
+}
+
+func Test_admission_func(t *testing.T) {
+	errQuota := errors.New("tenant over quota")
+	admit := func(ctx context.Context, name string) (bool, error) {
+		if name == "tenant-b" {
+			return false, errQuota
+		}
+		return true, nil
+	}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithFailureThreshold(1), WithAdmissionFunc(admit))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var ran int32
+	denied := &named{running: running{counter: &counter{}, ran: &ran}, name: "tenant-b"}
+	err := <-b.Execute(denied)
+	if err.Reason() != ReasonDenied || !errors.Is(err, errQuota) || ran != 0 {
+		t.Errorf("Was expecting tenant-b denied without running, instead got %v", err)
+	}
+	if b.limiter.InFlight() != 0 || b.State() != StateClosed {
+		t.Errorf("Was expecting no token taken and the circuit closed, instead got %d and %v", b.limiter.InFlight(), b.State())
+	}
+	allowed := &named{running: running{counter: &counter{}, ran: &ran}, name: "tenant-a"}
+	if err := <-b.Execute(allowed); !err.Success() || ran != 1 {
+		t.Errorf("Was expecting tenant-a to run, instead got %v", err)
+	}
+}
+
+func BenchmarkExecute(bm *testing.B) {
+	b := New("name", time.Second, 100)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &counter{}
+	bm.ReportAllocs()
+	bm.ResetTimer()
+	for i := 0; i < bm.N; i++ {
+		<-b.Execute(w)
+	}
+}
+
+func BenchmarkExecuteParallel(bm *testing.B) {
+	b := New("name", time.Second, 100)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	bm.ReportAllocs()
+	bm.ResetTimer()
+	bm.RunParallel(func(pb *testing.PB) {
+		w := &counter{}
+		for pb.Next() {
+			<-b.Execute(w)
+		}
+	})
+}
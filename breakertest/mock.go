@@ -0,0 +1,57 @@
+// Package breakertest provides helpers for testing code that runs commands through a breaker
+package breakertest
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rvauradkar1/breaker"
+)
+
+// MockCommand is a command with settable behavior that counts the calls to its methods. Set its
+// fields before submitting it, the counters are safe for concurrent use
+type MockCommand struct {
+	CommandName string        // Returned by Name, "mock" if empty
+	Sleep       time.Duration // CommandFunc sleeps this long before doing anything else
+	Panic       interface{}   // CommandFunc panics with it if not nil
+	Err         error         // Returned by Failure, a non nil error fails the call
+	CallTimeout time.Duration // Returned by Timeout, zero keeps the timeout of the breaker
+	commands    int32
+	defaults    int32
+	cleanups    int32
+}
+
+var (
+	_ breaker.CommandFuncs = (*MockCommand)(nil)
+	_ breaker.Timeout      = (*MockCommand)(nil)
+	_ breaker.Failer       = (*MockCommand)(nil)
+)
+
+func (m *MockCommand) Name() string {
+	if m.CommandName == "" {
+		return "mock"
+	}
+	return m.CommandName
+}
+
+func (m *MockCommand) CommandFunc() {
+	atomic.AddInt32(&m.commands, 1)
+	time.Sleep(m.Sleep)
+	if m.Panic != nil {
+		panic(m.Panic)
+	}
+}
+
+func (m *MockCommand) DefaultFunc()           { atomic.AddInt32(&m.defaults, 1) }
+func (m *MockCommand) CleanupFunc()           { atomic.AddInt32(&m.cleanups, 1) }
+func (m *MockCommand) Failure() error         { return m.Err }
+func (m *MockCommand) Timeout() time.Duration { return m.CallTimeout }
+
+// Commands returns the calls to CommandFunc
+func (m *MockCommand) Commands() int { return int(atomic.LoadInt32(&m.commands)) }
+
+// Defaults returns the calls to DefaultFunc
+func (m *MockCommand) Defaults() int { return int(atomic.LoadInt32(&m.defaults)) }
+
+// Cleanups returns the calls to CleanupFunc
+func (m *MockCommand) Cleanups() int { return int(atomic.LoadInt32(&m.cleanups)) }
@@ -0,0 +1,24 @@
+package breakertest
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rvauradkar1/breaker"
+)
+
+// Asserts that a slow dependency falls back to the default behavior
+func ExampleMockCommand() {
+	b := breaker.New("name", time.Second, 10)
+	defer b.Shutdown()
+	slow := &MockCommand{Sleep: 50 * time.Millisecond, CallTimeout: 10 * time.Millisecond}
+	err := <-b.Execute(slow)
+	fmt.Println(err.Timeout(), slow.Defaults(), slow.Cleanups())
+	failing := &MockCommand{Err: errors.New("down")}
+	err = <-b.Execute(failing)
+	fmt.Println(err.Reason(), failing.Commands(), failing.Defaults())
+	// Output:
+	// true 1 1
+	// failed 1 1
+}
@@ -0,0 +1,24 @@
+package breaker
+
+import "time"
+
+// clock abstracts time so that tests can control it
+type clock interface {
+	Now() time.Time
+}
+
+// realClock readings carry the monotonic clock, durations between them are immune to wall clock
+// adjustments as long as the readings are not stripped by Round, Truncate, UTC or a round trip
+// through Unix time
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// since is the duration elapsed since t, measure every duration with it. Never negative, even with
+// a clock whose readings lack the monotonic clock and step back
+func (b *Breaker) since(t time.Time) time.Duration {
+	if d := b.clock.Now().Sub(t); d > 0 {
+		return d
+	}
+	return 0
+}
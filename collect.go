@@ -0,0 +1,22 @@
+package breaker
+
+import "sync"
+
+// Collect multiplexes the channels returned by Execute into one, receiving each Error as its call
+// completes. The channel is closed once every call has completed
+func Collect(chans ...chan Error) <-chan Error {
+	out := make(chan Error, len(chans))
+	var wg sync.WaitGroup
+	wg.Add(len(chans))
+	for _, ch := range chans {
+		go func(ch chan Error) {
+			defer wg.Done()
+			out <- <-ch
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
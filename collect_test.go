@@ -0,0 +1,24 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_collect(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 3)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	counts := map[Reason]int{}
+	for err := range Collect(b.Execute(&wrapper3{}), b.Execute(&panicker{}), b.Execute(w), b.Execute(nil)) {
+		counts[err.Reason()]++
+	}
+	want := map[Reason]int{ReasonNone: 1, ReasonPanic: 1, ReasonTimeout: 1, ReasonInvalid: 1}
+	for r, n := range want {
+		if counts[r] != n {
+			t.Errorf("Was expecting %d %v, instead got %v", n, r, counts)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package breaker
+
+import "context"
+
+// Command is the typed form of CommandFuncs, preferred for new code. Run does the work, honouring
+// ctx, Fallback provides the result of a call that failed for any reason, given the Error
+type Command[T any] interface {
+	Name() string
+	Run(ctx context.Context) (T, error)
+	Fallback(ctx context.Context, err error) (T, error)
+}
+
+// typed adapts a Command to CommandFuncs
+type typed[T any] struct {
+	c     Command[T]
+	value T
+	err   error
+}
+
+// Do runs c under b and returns its result, or the result of its Fallback if the call failed:
+// rejected, timed out, panicked or Run returned an error
+func Do[T any](ctx context.Context, b *Breaker, c Command[T], opts ...CallOption) (T, error) {
+	t := &typed[T]{c: c}
+	be := <-b.ExecuteContext(ctx, t, opts...)
+	if be.Success() {
+		return t.value, nil
+	}
+	return c.Fallback(ctx, be)
+}
+
+func (t *typed[T]) CommandFuncCtx(ctx context.Context) {
+	v, err := t.c.Run(ctx)
+	t.value, t.err = v, err
+}
+
+func (t *typed[T]) failure() error { return t.err }
+func (t *typed[T]) CommandFunc()   { t.CommandFuncCtx(context.Background()) }
+func (t *typed[T]) DefaultFunc()   {}
+func (t *typed[T]) CleanupFunc()   {}
+func (t *typed[T]) Name() string   { return t.c.Name() }
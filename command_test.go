@@ -0,0 +1,63 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// lookup returns its value after delay or fails with err, falling back to "fallback"
+type lookup struct {
+	value string
+	delay time.Duration
+	err   error
+	cause error // Given to Fallback
+}
+
+func (l *lookup) Name() string { return "lookup" }
+
+func (l *lookup) Run(ctx context.Context) (string, error) {
+	select {
+	case <-time.After(l.delay):
+		return l.value, l.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (l *lookup) Fallback(ctx context.Context, err error) (string, error) {
+	l.cause = err
+	return "fallback", nil
+}
+
+func Test_do(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	broken := errors.New("broken")
+	tests := []struct {
+		name   string
+		l      *lookup
+		want   string
+		reason Reason
+	}{
+		{"success", &lookup{value: "found"}, "found", ReasonNone},
+		{"timeout", &lookup{value: "found", delay: time.Second}, "fallback", ReasonTimeout},
+		{"error", &lookup{err: broken}, "fallback", ReasonFailed},
+	}
+	for _, tt := range tests {
+		v, err := Do[string](context.Background(), b, tt.l)
+		if err != nil || v != tt.want {
+			t.Errorf("%s: Was expecting %q, instead got %q %v", tt.name, tt.want, v, err)
+		}
+		var be Error
+		if tt.reason != ReasonNone && (!errors.As(tt.l.cause, &be) || be.Reason() != tt.reason) {
+			t.Errorf("%s: Was expecting fallback on %v, instead got %v", tt.name, tt.reason, tt.l.cause)
+		}
+		waitFor(func() bool { return b.limiter.InFlight() == 0 })
+	}
+	if !errors.Is(tests[2].l.cause, broken) {
+		t.Errorf("Fallback error should wrap the error of Run, instead got %v", tests[2].l.cause)
+	}
+}
@@ -0,0 +1,124 @@
+package breaker
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Composite combines breakers protecting a call that touches several downstreams
+type Composite struct {
+	members []*Breaker
+	all     bool
+}
+
+// All admits a call only if every breaker admits it. Breakers are tried in order, tokens already
+// obtained are released as soon as one breaker rejects
+func All(breakers ...*Breaker) *Composite {
+	return &Composite{members: breakers, all: true}
+}
+
+// Any admits a call if at least one breaker admits it. Tokens are taken from every breaker that admits
+func Any(breakers ...*Breaker) *Composite {
+	return &Composite{members: breakers}
+}
+
+// Execute is called by clients to initiate task, see ExecuteContext
+func (c *Composite) Execute(commands CommandFuncs, opts ...CallOption) chan Error {
+	return c.ExecuteContext(context.Background(), commands, opts...)
+}
+
+// ExecuteContext runs the command once under the shortest timeout of the admitting breakers. The outcome
+// is recorded to every admitting breaker, a rejection only to the breakers that rejected. The first
+// breaker owns the call: its callbacks follow the options of that breaker, such as
+// WithSerializedCallbacks and WithCleanupOnRejection
+func (c *Composite) ExecuteContext(ctx context.Context, commands CommandFuncs, opts ...CallOption) chan Error {
+	errorch := make(chan Error, 1)
+	if len(c.members) == 0 || commands == nil {
+		errorch <- Error{reason: ReasonInvalid, Err: errors.New("nothing to run, cannot run your command")}
+		return errorch
+	}
+	owner := c.members[0]
+	cl := newCall(opts)
+	cl.serial = owner.serial
+	cl.id = atomic.AddUint64(&owner.lastCall, 1)
+	cl.name = owner.commandName(commands)
+	deliver := func(r Result, be Error) { errorch <- be }
+	submitted := owner.clock.Now()
+	if err := ctx.Err(); err != nil {
+		cl.observers = c.members
+		owner.fallback(cl, commands)
+		owner.finish(deliver, commands, cl, OutcomeIgnored, submitted, Error{reason: ReasonCanceled, Err: err})
+		return errorch
+	}
+	if !owner.reserve(1) {
+		owner.fallback(cl, commands)
+		be := Error{reason: ReasonSaturated, Err: errors.New("reached goroutine limit, cannot run your command")}
+		owner.finish(deliver, commands, cl, OutcomeRejected, submitted, be)
+		return errorch
+	}
+	owner.spawnReserved(func() {
+		var admitted []*Breaker
+		var releases []func()
+		var rejected []*Breaker
+		var err error
+		for _, b := range c.members {
+			release, e := b.admit(ctx, cl)
+			if e != nil {
+				err = e
+				rejected = append(rejected, b)
+				if c.all {
+					break
+				}
+				continue
+			}
+			admitted = append(admitted, b)
+			releases = append(releases, release)
+		}
+		cl.queue = owner.since(submitted)
+		releaseAll := func() {
+			for i := len(releases) - 1; i >= 0; i-- {
+				releases[i]()
+			}
+		}
+		if len(admitted) == 0 || (c.all && err != nil) {
+			releaseAll()
+			owner.fallback(cl, commands)
+			cl.observers = rejected
+			// Named after the breaker whose rejection is reported
+			last := rejected[len(rejected)-1]
+			if err == ctx.Err() {
+				// Canceled or out of time while queued
+				outcome := owner.classify(err)
+				last.finish(deliver, commands, cl, outcome, submitted, Error{isTimeout: outcome == OutcomeTimeout, reason: ReasonCanceled, Err: err})
+				return
+			}
+			last.finish(deliver, commands, cl, OutcomeRejected, submitted, Error{reason: reasonOf(err), Err: err})
+			return
+		}
+		cl.takeTicket()
+		rctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		for _, b := range admitted {
+			defer b.untrack(b.track(cl, cancel))
+		}
+		runner := admitted[0]
+		timeout := runner.commandTimeout(commands)
+		for _, b := range admitted[1:] {
+			if t := b.commandTimeout(commands); t < timeout {
+				runner, timeout = b, t
+			}
+		}
+		if cl.timeout > 0 {
+			timeout = cl.timeout
+		}
+		start := runner.clock.Now()
+		outcome, be := runner.run(rctx, commands, cl, timeout)
+		cl.service = runner.since(start)
+		releaseAll()
+		cl.observers = admitted
+		runner.finish(deliver, commands, cl, outcome, submitted, be)
+	})
+	return errorch
+}
@@ -0,0 +1,91 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newQuiet(name string, numConcurrent int) *Breaker {
+	b := New(name, time.Second, numConcurrent)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	return b
+}
+
+func Test_all_requires_every_breaker(t *testing.T) {
+	b1, b2 := newQuiet("b1", 1), newQuiet("b2", 1)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	var count1, count2 int
+	b1.OnComplete(func(name string, outcome Outcome, d time.Duration) { count1++ })
+	b2.OnComplete(func(name string, outcome Outcome, d time.Duration) { count2++ })
+	if err := <-All(b1, b2).Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Was expecting success, instead got %v", err)
+	}
+	if count1 != 1 || count2 != 1 {
+		t.Errorf("Outcome should be recorded to both breakers, got %d and %d", count1, count2)
+	}
+	b2.trip("test")
+	w := &wrapper{}
+	if err := <-All(b1, b2).Execute(w); err.Success() || w.exec {
+		t.Errorf("Open member should reject the call")
+	}
+	if b1.limiter.InFlight() != 0 {
+		t.Errorf("Token of admitting member should be released, in flight %d", b1.limiter.InFlight())
+	}
+}
+
+func Test_any_requires_one_breaker(t *testing.T) {
+	b1, b2 := newQuiet("b1", 1), newQuiet("b2", 1)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	b1.trip("test")
+	if err := <-Any(b1, b2).Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Was expecting success, instead got %v", err)
+	}
+	b2.trip("test")
+	w := &wrapper{}
+	if err := <-Any(b1, b2).Execute(w); err.Success() || w.exec {
+		t.Errorf("Call should be rejected when every member rejects")
+	}
+}
+
+func Test_composite_follows_owner_options(t *testing.T) {
+	b1 := NewWithOptions("b1", WithTimeout(time.Second), WithConcurrency(1), WithCleanupOnRejection(false))
+	b1.SetHealthCheckInterval(100000 * time.Millisecond)
+	b2 := newQuiet("b2", 1)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	b2.trip("test")
+	w := &counter{}
+	err := <-All(b1, b2).Execute(w)
+	if err.Reason() != ReasonOpen || err.Name() != "b2" || err.CallID() == 0 {
+		t.Errorf("Was expecting a rejection by b2 with a call id, instead got %v from %q", err, err.Name())
+	}
+	if w.defaults != 1 || w.cleanups != 0 {
+		t.Errorf("Was expecting the fallback without cleanup, instead got %d and %d", w.defaults, w.cleanups)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var ran int32
+	err = <-Any(b1, b2).ExecuteContext(ctx, &running{counter: &counter{}, ran: &ran})
+	if err.Reason() != ReasonCanceled || ran != 0 || b1.limiter.InFlight() != 0 {
+		t.Errorf("Was expecting a done context to be rejected without a token, instead got %v", err)
+	}
+}
+
+func Test_composite_tracks_in_flight(t *testing.T) {
+	b1, b2 := newQuiet("b1", 1), newQuiet("b2", 1)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	w := &waiter{counter: &counter{}, started: make(chan bool, 1)}
+	ch := All(b1, b2).Execute(w)
+	<-w.started
+	if len(b1.InFlightCalls()) != 1 || len(b2.InFlightCalls()) != 1 {
+		t.Errorf("Was expecting the call in flight on both breakers")
+	}
+	b2.ForceOpenWithCancel()
+	if err := <-ch; err.Reason() != ReasonCanceled {
+		t.Errorf("Was expecting the call canceled, instead got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package breaker
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config is a read only copy of the effective configuration of a breaker
+type Config struct {
+	Name                string
+	Timeout             time.Duration
+	EffectiveTimeout    time.Duration // Timeout after WithAdaptiveTimeout, same as Timeout without it
+	Concurrency         int
+	HealthCheckInterval time.Duration
+	Warmup              time.Duration
+	MaxGoroutines       int
+	FailureThreshold    int
+	SuccessThreshold    int
+}
+
+// Config returns a copy of the effective configuration, safe to call concurrently
+func (b *Breaker) Config() Config {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Config{
+		Name:                b.name,
+		Timeout:             b.timeout,
+		EffectiveTimeout:    b.adaptedTimeout(),
+		Concurrency:         b.numConcurrent,
+		HealthCheckInterval: b.HealthCheckInterval * time.Millisecond,
+		Warmup:              b.warmup,
+		MaxGoroutines:       int(b.maxGoroutines),
+		FailureThreshold:    b.failureThreshold,
+		SuccessThreshold:    b.successThreshold,
+	}
+}
+
+// ConfigError lists the problems NewChecked found in a configuration
+type ConfigError struct {
+	Name     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration of breaker %s: %s", e.Name, strings.Join(e.Problems, ", "))
+}
+
+// NewChecked is NewWithOptions refusing invalid configurations with a *ConfigError instead of
+// creating a breaker that misbehaves, such as one timing out every call
+func NewChecked(name string, opts ...Option) (*Breaker, error) {
+	b := configure(name, opts)
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+	return b.start(), nil
+}
+
+// validate checks a configured breaker before it is started
+func (b *Breaker) validate() error {
+	var problems []string
+	if b.name == "" {
+		problems = append(problems, "empty name")
+	}
+	if b.timeout <= 0 {
+		problems = append(problems, fmt.Sprintf("timeout %v is not positive", b.timeout))
+	} else if b.timeout < minTimeout {
+		problems = append(problems, fmt.Sprintf("timeout %v is shorter than %v, every call would time out", b.timeout, minTimeout))
+	}
+	if b.limiter == nil && b.numConcurrent <= 0 {
+		problems = append(problems, fmt.Sprintf("concurrency %d is not positive", b.numConcurrent))
+	}
+	if b.HealthCheckInterval <= 0 {
+		problems = append(problems, fmt.Sprintf("healthcheck interval %v is not positive", b.HealthCheckInterval))
+	}
+	if b.failureThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("failure threshold %d is negative", b.failureThreshold))
+	}
+	if b.successThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("success threshold %d is negative", b.successThreshold))
+	}
+	if b.successThreshold > 1 && b.recoveryPolicy != nil {
+		problems = append(problems, "success threshold has no effect with a recovery policy, the circuit is never half open")
+	}
+	if b.maxGoroutines < 0 || b.maxQueued < 0 {
+		problems = append(problems, "negative goroutine or queue limit")
+	}
+	if b.jitter < 0 || b.jitter > 1 {
+		problems = append(problems, fmt.Sprintf("healthcheck jitter %v is outside [0,1]", b.jitter))
+	}
+	for _, step := range b.recoverySteps {
+		if step <= 0 || step > 1 {
+			problems = append(problems, fmt.Sprintf("recovery step %v is outside (0,1]", step))
+		}
+	}
+	if a := b.adaptive; a != nil {
+		if a.multiplier <= 0 {
+			problems = append(problems, fmt.Sprintf("adaptive timeout multiplier %v is not positive", a.multiplier))
+		}
+		if a.max > 0 && a.min > a.max {
+			problems = append(problems, fmt.Sprintf("adaptive timeout min %v exceeds max %v", a.min, a.max))
+		}
+	}
+	if len(problems) > 0 {
+		return &ConfigError{Name: b.name, Problems: problems}
+	}
+	return nil
+}
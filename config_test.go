@@ -0,0 +1,65 @@
+package breaker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_config_reflects_options(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(7), WithWarmup(time.Minute), WithMaxGoroutines(30),
+		WithFailureThreshold(5), WithSuccessThreshold(2))
+	defer b.Shutdown()
+	want := Config{
+		Name:                "name",
+		Timeout:             time.Second,
+		EffectiveTimeout:    time.Second,
+		Concurrency:         7,
+		HealthCheckInterval: 100 * time.Millisecond,
+		Warmup:              time.Minute,
+		MaxGoroutines:       30,
+		FailureThreshold:    5,
+		SuccessThreshold:    2,
+	}
+	if got := b.Config(); got != want {
+		t.Errorf("Was expecting %+v, instead got %+v", want, got)
+	}
+}
+
+func Test_config_adaptive_timeout(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithAdaptiveTimeout(2, time.Millisecond, time.Second))
+	defer b.Shutdown()
+	b.observeLatency(50 * time.Millisecond)
+	if got := b.Config(); got.Timeout != time.Second || got.EffectiveTimeout != 100*time.Millisecond {
+		t.Errorf("Was expecting a timeout of 1s adapted to 100ms, instead got %v and %v", got.Timeout, got.EffectiveTimeout)
+	}
+}
+
+func Test_new_checked(t *testing.T) {
+	b, err := NewChecked("name", WithTimeout(time.Second), WithConcurrency(1))
+	if err != nil {
+		t.Fatalf("Valid configuration should be accepted, instead got %v", err)
+	}
+	b.Shutdown()
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"negative timeout", []Option{WithTimeout(-time.Second), WithConcurrency(1)}, "timeout -1s is not positive"},
+		{"no concurrency", []Option{WithTimeout(time.Second)}, "concurrency 0 is not positive"},
+		{"success threshold without half open", []Option{WithTimeout(time.Second), WithConcurrency(1),
+			WithSuccessThreshold(3), WithRecoveryPolicy(CapacityAvailable)}, "never half open"},
+		{"jitter", []Option{WithTimeout(time.Second), WithConcurrency(1), WithHealthCheckJitter(2)}, "outside [0,1]"},
+		{"adaptive clamps", []Option{WithTimeout(time.Second), WithConcurrency(1),
+			WithAdaptiveTimeout(2, time.Second, time.Millisecond)}, "exceeds max"},
+	}
+	for _, tt := range tests {
+		b, err := NewChecked(tt.name, tt.opts...)
+		var ce *ConfigError
+		if b != nil || !errors.As(err, &ce) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Was expecting a ConfigError with %q, instead got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package breaker
+
+import "context"
+
+type breakerKey struct{}
+
+// FromContext returns the breaker running the command, from the context passed to CommandFuncCtx.
+// Meant for inspection such as State, commands should not reconfigure or shutdown the breaker.
+// Returns nil outside of a breaker
+func FromContext(ctx context.Context) *Breaker {
+	b, _ := ctx.Value(breakerKey{}).(*Breaker)
+	return b
+}
@@ -0,0 +1,139 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_context_canceled_is_ignored(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var got Outcome = -1
+	b.OnComplete(func(name string, outcome Outcome, d time.Duration) { got = outcome })
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	ch := b.ExecuteContext(ctx, w)
+	cancel()
+	err := <-ch
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Was expecting context.Canceled, instead got %v", err)
+	}
+	if err.Timeout() {
+		t.Errorf("Canceled call should not be a timeout")
+	}
+	if got != OutcomeIgnored {
+		t.Errorf("Was expecting %v, instead got %v", OutcomeIgnored, got)
+	}
+}
+
+func Test_context_deadline_is_timeout(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var got Outcome = -1
+	b.OnComplete(func(name string, outcome Outcome, d time.Duration) { got = outcome })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	err := <-b.ExecuteContext(ctx, w)
+	if !errors.Is(err, context.DeadlineExceeded) || !err.Timeout() {
+		t.Errorf("Was expecting a deadline timeout, instead got %v", err)
+	}
+	if got != OutcomeTimeout {
+		t.Errorf("Was expecting %v, instead got %v", OutcomeTimeout, got)
+	}
+}
+
+func Test_custom_classifier(t *testing.T) {
+	classifier := func(err error) Outcome { return OutcomeTimeout }
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithClassifier(classifier))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	ch := b.ExecuteContext(ctx, w)
+	cancel()
+	if err := <-ch; !err.Timeout() {
+		t.Errorf("Classifier should have made cancellation a timeout, instead got %v", err)
+	}
+}
+
+func Test_done_context_not_admitted(t *testing.T) {
+	l := &loggingLimiter{inner: newChanLimiter(1)}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &wrapper{}
+	err := <-b.ExecuteContext(ctx, w)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Was expecting context.Canceled, instead got %v", err)
+	}
+	if w.exec {
+		t.Errorf("Command should not have run")
+	}
+	if len(l.log()) != 0 {
+		t.Errorf("No token should have been acquired, got %v", l.log())
+	}
+}
+
+// inspector records the breaker found in its context
+type inspector struct {
+	found *Breaker
+}
+
+func (w *inspector) CommandFuncCtx(ctx context.Context) { w.found = FromContext(ctx) }
+func (w *inspector) CommandFunc()                       {}
+func (w *inspector) DefaultFunc()                       {}
+func (w *inspector) CleanupFunc()                       {}
+func (w *inspector) Name() string                       { return "inspector" }
+
+func Test_breaker_from_context(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &inspector{}
+	if err := <-b.ExecuteContext(context.Background(), w); !err.Success() {
+		t.Fatalf("Was expecting success, instead got %v", err)
+	}
+	if w.found != b {
+		t.Errorf("Command should find its breaker in the context")
+	}
+	if FromContext(context.Background()) != nil {
+		t.Errorf("No breaker should be found outside of a breaker")
+	}
+}
+
+func Test_trial_context(t *testing.T) {
+	b := New("name", time.Second, 2)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	b.trip("test")
+	b.triggerHealthCheck()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := b.ExecuteContext(ctx, w)
+	waitFor(func() bool { return b.limiter.InFlight() == 1 })
+	cancel()
+	<-ch
+	if b.State() != StateHalfOpen {
+		t.Errorf("Canceled trial should not penalize the circuit, instead got %v", b.State())
+	}
+	waitFor(func() bool { return b.limiter.InFlight() == 0 })
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := <-b.ExecuteContext(ctx, w); !err.Timeout() {
+		t.Errorf("Was expecting the trial to time out with its context, instead got %v", err)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Trial past its deadline should reopen the circuit, instead got %v", b.State())
+	}
+}
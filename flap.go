@@ -0,0 +1,64 @@
+package breaker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// tripWindow is the period Stats.TripsLastHour counts trips over
+const tripWindow = time.Hour
+
+// flap is the flap detection set with OnFlap
+type flap struct {
+	threshold int
+	window    time.Duration
+	f         func(name string, trips int)
+}
+
+// OnFlap registers a callback invoked each time the circuit opens while it already opened more than
+// threshold times within window, a sign of a failure threshold too aggressive for the dependency.
+// The callback is invoked outside of any lock
+func (b *Breaker) OnFlap(threshold int, window time.Duration, f func(name string, trips int)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flap = &flap{threshold: threshold, window: window, f: f}
+}
+
+// tripped records a trip and notifies a flap, called without holding mu
+func (b *Breaker) tripped() {
+	if b.dryRun {
+		atomic.AddInt64(&b.wouldTrip, 1)
+	}
+	now := b.clock.Now()
+	b.mu.Lock()
+	keep := tripWindow
+	if b.flap != nil && b.flap.window > keep {
+		keep = b.flap.window
+	}
+	b.trips = append(pruneBefore(b.trips, now.Add(-keep)), now)
+	fl := b.flap
+	n := 0
+	if fl != nil {
+		n = len(pruneBefore(b.trips, now.Add(-fl.window)))
+	}
+	b.mu.Unlock()
+	if fl != nil && n > fl.threshold {
+		fl.f(b.name, n)
+	}
+}
+
+// tripsSince counts the trips after t
+func (b *Breaker) tripsSince(t time.Time) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(pruneBefore(b.trips, t))
+}
+
+// pruneBefore drops the times before t from the oldest first times
+func pruneBefore(times []time.Time, t time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(t) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,70 @@
+package breaker
+
+import "sync"
+
+// graphMu guards the dependency edges of all breakers, edges span breakers so no single breaker owns them
+var graphMu sync.Mutex
+
+// DependsOn records that b depends on deps, such as a service on the breakers of its endpoints. Only
+// bookkeeping for Dependencies and Dependents, unless WithOpenOnDependencies. WithParent records the
+// parent as depending on the child
+func (b *Breaker) DependsOn(deps ...*Breaker) *Breaker {
+	graphMu.Lock()
+	defer graphMu.Unlock()
+	for _, d := range deps {
+		if d == nil || d == b || contains(b.dependencies, d) {
+			continue
+		}
+		b.dependencies = append(b.dependencies, d)
+		d.dependents = append(d.dependents, b)
+	}
+	return b
+}
+
+// Dependencies returns the breakers b depends on, in the order they were recorded
+func (b *Breaker) Dependencies() []*Breaker {
+	graphMu.Lock()
+	defer graphMu.Unlock()
+	return append([]*Breaker(nil), b.dependencies...)
+}
+
+// Dependents returns the breakers depending on b, in the order they were recorded
+func (b *Breaker) Dependents() []*Breaker {
+	graphMu.Lock()
+	defer graphMu.Unlock()
+	return append([]*Breaker(nil), b.dependents...)
+}
+
+// WithOpenOnDependencies trips the breaker when all its dependencies are open, there is no point
+// taking load none of them can serve. The breaker then recovers by its own healthcheck
+func WithOpenOnDependencies() Option {
+	return func(b *Breaker) { b.openOnDependencies = true }
+}
+
+// dependencyOpened trips the dependents of b that follow their dependencies and have all of them open
+func (b *Breaker) dependencyOpened() {
+	for _, d := range b.Dependents() {
+		if !d.openOnDependencies {
+			continue
+		}
+		all := true
+		for _, dep := range d.Dependencies() {
+			if dep.State() != StateOpen {
+				all = false
+				break
+			}
+		}
+		if all {
+			d.trip("all dependencies open")
+		}
+	}
+}
+
+func contains(breakers []*Breaker, b *Breaker) bool {
+	for _, c := range breakers {
+		if c == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package breaker
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Outcome of a call submitted to Execute
+type Outcome int
+
+const (
+	OutcomeSuccess  Outcome = iota // Command completed within timeout
+	OutcomeTimeout                 // Command did not complete within timeout
+	OutcomeRejected                // Command was not admitted
+	OutcomeIgnored                 // Call abandoned by the client, neither success nor failure
+	OutcomePanic                   // Command panicked
+	OutcomeFailure                 // Command returned an error
+	OutcomeHedged                  // Fallback completed before the command, see WithHedge
+	numOutcomes
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSuccess:
+		return "success"
+	case OutcomeTimeout:
+		return "timeout"
+	case OutcomeRejected:
+		return "rejected"
+	case OutcomeIgnored:
+		return "ignored"
+	case OutcomePanic:
+		return "panic"
+	case OutcomeFailure:
+		return "failure"
+	case OutcomeHedged:
+		return "hedged"
+	}
+	return "unknown"
+}
+
+// Latencies are bucketed in microseconds, exact below 8us and then 8 buckets per power of 2,
+// which keeps the relative error of a percentile under 1/16
+const (
+	subBuckets = 8
+	numBuckets = (64-2)*subBuckets + subBuckets
+)
+
+// histogram is a lock free latency histogram, buckets are updated atomically
+type histogram struct {
+	counts [numBuckets]uint64
+}
+
+func bucketOf(d time.Duration) int {
+	v := uint64(d / time.Microsecond)
+	if d < 0 {
+		v = 0
+	}
+	if v < subBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	sub := (v >> uint(exp-3)) & (subBuckets - 1)
+	return (exp-2)*subBuckets + int(sub)
+}
+
+// bucketValue returns the midpoint of the bucket
+func bucketValue(i int) time.Duration {
+	if i < subBuckets {
+		return time.Duration(i) * time.Microsecond
+	}
+	exp := i/subBuckets + 2
+	sub := uint64(i % subBuckets)
+	width := uint64(1) << uint(exp-3)
+	lower := (subBuckets + sub) << uint(exp-3)
+	return time.Duration(lower+width/2) * time.Microsecond
+}
+
+func (h *histogram) record(d time.Duration) {
+	atomic.AddUint64(&h.counts[bucketOf(d)], 1)
+}
+
+// remove takes back a duration recorded earlier, unless a reset forgot it already
+func (h *histogram) remove(d time.Duration) {
+	c := &h.counts[bucketOf(d)]
+	for {
+		n := atomic.LoadUint64(c)
+		if n == 0 || atomic.CompareAndSwapUint64(c, n, n-1) {
+			return
+		}
+	}
+}
+
+func (h *histogram) reset() {
+	for i := range h.counts {
+		atomic.StoreUint64(&h.counts[i], 0)
+	}
+}
+
+func (h *histogram) snapshot() []uint64 {
+	counts := make([]uint64, numBuckets)
+	for i := range h.counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+	}
+	return counts
+}
+
+// percentile returns the latency below which p percent of the counts fall, 0 if there are none
+func percentile(counts []uint64, p float64) time.Duration {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(p / 100 * float64(total))
+	if rank >= total {
+		rank = total - 1
+	}
+	var seen uint64
+	for i, c := range counts {
+		seen += c
+		if seen > rank {
+			return bucketValue(i)
+		}
+	}
+	return 0
+}
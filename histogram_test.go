@@ -0,0 +1,41 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_bucket_round_trip(t *testing.T) {
+	for _, d := range []time.Duration{0, 3 * time.Microsecond, time.Millisecond, 37 * time.Millisecond, time.Minute} {
+		v := bucketValue(bucketOf(d))
+		if diff := v - d; diff < -d/16 || diff > d/16 {
+			t.Errorf("Bucket value %v too far from %v", v, d)
+		}
+	}
+}
+
+func Test_latency_percentiles(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	for i := 1; i <= 100; i++ {
+		b.latencies[OutcomeSuccess].record(time.Duration(i) * time.Millisecond)
+	}
+	b.latencies[OutcomeTimeout].record(time.Second)
+	p := b.Stats().LatencyPercentiles(50, 99)
+	near := func(got, want time.Duration) bool {
+		return got > want-want/10 && got < want+want/10
+	}
+	if !near(p[OutcomeSuccess][0], 50*time.Millisecond) {
+		t.Errorf("Was expecting p50 near 50ms, instead got %v", p[OutcomeSuccess][0])
+	}
+	if !near(p[OutcomeSuccess][1], 99*time.Millisecond) {
+		t.Errorf("Was expecting p99 near 99ms, instead got %v", p[OutcomeSuccess][1])
+	}
+	if !near(p[OutcomeTimeout][0], time.Second) {
+		t.Errorf("Was expecting timeout p50 near 1s, instead got %v", p[OutcomeTimeout][0])
+	}
+	if p[OutcomeRejected][0] != 0 {
+		t.Errorf("Was expecting no rejected latencies, instead got %v", p[OutcomeRejected][0])
+	}
+}
@@ -0,0 +1,127 @@
+package breaker
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryAfter is how long a client should wait before retrying a call rejected by an open circuit,
+// the time until the next healthcheck. Zero when the circuit is closed
+func (b *Breaker) RetryAfter() time.Duration {
+	if b.State() == StateClosed {
+		return 0
+	}
+	return b.healthCheckInterval()
+}
+
+// MiddlewareOption configures Middleware
+type MiddlewareOption func(m *middleware)
+
+type middleware struct {
+	retryAfter func(b *Breaker) time.Duration
+}
+
+// WithRetryAfterFunc computes the Retry-After header of rejected requests, defaults to RetryAfter
+func WithRetryAfterFunc(f func(b *Breaker) time.Duration) MiddlewareOption {
+	return func(m *middleware) { m.retryAfter = f }
+}
+
+// StatusClientClosedRequest is the non standard status of a request abandoned by its client
+const StatusClientClosedRequest = 499
+
+// HTTPStatus maps an error to the HTTP status answering it: 503 Service Unavailable when the circuit
+// is open, saturated or shut down, 504 Gateway Timeout on timeout, 499 when the call was canceled,
+// 429 Too Many Requests when denied by the admission func, 200 OK without error and 500 Internal
+// Server Error for any other error
+func HTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var be Error
+	if !errors.As(err, &be) {
+		return http.StatusInternalServerError
+	}
+	switch be.Reason() {
+	case ReasonNone:
+		return http.StatusOK
+	case ReasonOpen, ReasonSaturated, ReasonShutdown:
+		return http.StatusServiceUnavailable
+	case ReasonTimeout:
+		return http.StatusGatewayTimeout
+	case ReasonCanceled:
+		return StatusClientClosedRequest
+	case ReasonDenied:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// Middleware serves requests through b. A request that is not admitted is answered with the status
+// given by HTTPStatus, 503 Service Unavailable with a Retry-After header when the circuit is open. A
+// request that was admitted is answered by next alone, even when it times out: its context is done
+// and Middleware waits for next to return
+func Middleware(b *Breaker, next http.Handler, opts ...MiddlewareOption) http.Handler {
+	m := &middleware{retryAfter: (*Breaker).RetryAfter}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := &serving{next: next, w: w, r: r, done: make(chan struct{})}
+		be := <-b.ExecuteContext(r.Context(), h)
+		if !h.claim(servingRejected) {
+			// next claimed the request first, it answers it
+			<-h.done
+			return
+		}
+		status := HTTPStatus(be)
+		if status == http.StatusServiceUnavailable {
+			seconds := int(math.Ceil(m.retryAfter(b).Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		}
+		w.WriteHeader(status)
+	})
+}
+
+// Who answers a request served through Middleware, claimed once
+const (
+	servingPending  int32 = iota
+	servingByNext         // next was called, Middleware waits for it
+	servingRejected       // Middleware writes the status, next is never called
+)
+
+// serving adapts a request to CommandFuncs
+type serving struct {
+	next  http.Handler
+	w     http.ResponseWriter
+	r     *http.Request
+	state int32 // Updated atomically, see claim
+	done  chan struct{}
+}
+
+// claim records by as the one answering the request, false if it was already claimed. The command
+// may only start after the call timed out or was canceled, the ResponseWriter is then Middleware's
+func (s *serving) claim(by int32) bool {
+	return atomic.CompareAndSwapInt32(&s.state, servingPending, by)
+}
+
+func (s *serving) CommandFuncCtx(ctx context.Context) {
+	if !s.claim(servingByNext) {
+		return
+	}
+	defer close(s.done)
+	s.next.ServeHTTP(s.w, s.r.WithContext(ctx))
+}
+
+func (s *serving) CommandFunc() { s.CommandFuncCtx(s.r.Context()) }
+func (s *serving) DefaultFunc() {}
+func (s *serving) CleanupFunc() {}
+func (s *serving) Name() string { return s.r.Method + " " + s.r.URL.Path }
@@ -0,0 +1,77 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_middleware_retry_after(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	h := Middleware(b, ok, WithRetryAfterFunc(func(b *Breaker) time.Duration { return 7 * time.Second }))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Was expecting the handler to answer, instead got %d", rec.Code)
+	}
+	b.trip("test")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "7" {
+		t.Errorf("Was expecting 503 with the custom Retry-After, instead got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	rec = httptest.NewRecorder()
+	Middleware(b, ok).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("Retry-After") != "100" {
+		t.Errorf("Was expecting Retry-After to default to the healthcheck interval, instead got %q", rec.Header().Get("Retry-After"))
+	}
+}
+
+func Test_serving_claimed_once(t *testing.T) {
+	var served int32
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt32(&served, 1) })
+	// Timed out or canceled before the command goroutine was scheduled, Middleware answers
+	h := &serving{next: next, w: httptest.NewRecorder(), r: httptest.NewRequest("GET", "/", nil), done: make(chan struct{})}
+	if !h.claim(servingRejected) {
+		t.Fatalf("Was expecting Middleware to claim a request next has not started")
+	}
+	h.CommandFuncCtx(context.Background())
+	if atomic.LoadInt32(&served) != 0 {
+		t.Errorf("Was expecting next not to be called on a request claimed by Middleware")
+	}
+	// next started first, Middleware leaves the request to it
+	h = &serving{next: next, w: httptest.NewRecorder(), r: httptest.NewRequest("GET", "/", nil), done: make(chan struct{})}
+	h.CommandFuncCtx(context.Background())
+	if atomic.LoadInt32(&served) != 1 || h.claim(servingRejected) {
+		t.Errorf("Was expecting next to answer the request it claimed")
+	}
+}
+
+func Test_http_status(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{Error{isSuccess: true}, http.StatusOK},
+		{Error{reason: ReasonOpen}, http.StatusServiceUnavailable},
+		{Error{reason: ReasonSaturated}, http.StatusServiceUnavailable},
+		{Error{reason: ReasonShutdown, isShutdown: true}, http.StatusServiceUnavailable},
+		{Error{reason: ReasonTimeout, isTimeout: true}, http.StatusGatewayTimeout},
+		{Error{reason: ReasonCanceled}, StatusClientClosedRequest},
+		{Error{reason: ReasonPanic, isPanic: true}, http.StatusInternalServerError},
+		{errors.New("other"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := HTTPStatus(tt.err); got != tt.want {
+			t.Errorf("Was expecting %d for %+v, instead got %d", tt.want, tt.err, got)
+		}
+	}
+}
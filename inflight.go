@@ -0,0 +1,63 @@
+package breaker
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// InFlightInfo describes a call holding tokens
+type InFlightInfo struct {
+	Name  string    // Name of the command
+	Since time.Time // When the call was admitted
+}
+
+// InFlightCalls returns the calls currently holding tokens, longest held first. Helps find the slow
+// command hogging capacity
+func (b *Breaker) InFlightCalls() []InFlightInfo {
+	b.holdersMu.Lock()
+	calls := make([]InFlightInfo, 0, len(b.holders))
+	for _, info := range b.holders {
+		calls = append(calls, info)
+	}
+	b.holdersMu.Unlock()
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Since.Before(calls[j].Since) })
+	return calls
+}
+
+// track records an admitted call until untrack is called with the returned id. cancel cancels the
+// context of the call, bypass calls are never canceled
+func (b *Breaker) track(c *call, cancel context.CancelFunc) uint64 {
+	b.holdersMu.Lock()
+	defer b.holdersMu.Unlock()
+	if b.holders == nil {
+		b.holders = map[uint64]InFlightInfo{}
+		b.cancels = map[uint64]context.CancelFunc{}
+	}
+	b.lastHolder++
+	b.holders[b.lastHolder] = InFlightInfo{Name: c.name, Since: b.clock.Now()}
+	if !c.bypass {
+		b.cancels[b.lastHolder] = cancel
+	}
+	return b.lastHolder
+}
+
+func (b *Breaker) untrack(id uint64) {
+	b.holdersMu.Lock()
+	defer b.holdersMu.Unlock()
+	delete(b.holders, id)
+	delete(b.cancels, id)
+}
+
+// cancelInFlight cancels the context of every call holding tokens, except bypass calls
+func (b *Breaker) cancelInFlight() {
+	b.holdersMu.Lock()
+	cancels := make([]context.CancelFunc, 0, len(b.cancels))
+	for _, cancel := range b.cancels {
+		cancels = append(cancels, cancel)
+	}
+	b.holdersMu.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
@@ -0,0 +1,93 @@
+package breaker
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// ExecuteKeyed is Execute with trip state kept apart per key, such as the downstream host of the
+// call, so failures of one key trip only that key. Calls of all keys share the capacity of the
+// breaker, and the breaker being open or shut down rejects all of them. Keyed calls only feed the
+// trip policy of their key. A key follows the trip policy and the logging options of the breaker,
+// its caps and probes stay with the breaker. Each key costs a healthcheck goroutine and is kept until
+// Shutdown, keys must be few
+func (b *Breaker) ExecuteKeyed(key string, commands CommandFuncs, opts ...CallOption) chan Error {
+	return b.key(key, true).Execute(commands, opts...)
+}
+
+// KeyState returns the state of key, see ExecuteKeyed. A key never used is closed
+func (b *Breaker) KeyState(key string) State {
+	if k := b.key(key, false); k != nil {
+		return k.State()
+	}
+	return StateClosed
+}
+
+// key returns the breaker keeping the trip state of key, created if create is set. A breaker that
+// is not initialized or shut down is its own key, Execute then rejects the call
+func (b *Breaker) key(key string, create bool) *Breaker {
+	if b == nil || b.limiter == nil {
+		return b
+	}
+	b.keysMu.Lock()
+	defer b.keysMu.Unlock()
+	if k, ok := b.keys[key]; ok || !create {
+		return k
+	}
+	if b.State() == StateShutdown {
+		return b
+	}
+	k := configure(b.name+"/"+key, []Option{
+		WithLimiter(&keyLimiter{}),
+		withHealthCheckInterval(b.healthCheckInterval()),
+	})
+	b.inherit(k)
+	k.isKey = true
+	// Not through WithParent, a key is no dependency of its breaker
+	k.parent = b
+	k.start()
+	if b.keys == nil {
+		b.keys = make(map[string]*Breaker)
+	}
+	b.keys[key] = k
+	return k
+}
+
+// inherit hands the trip policy and the logging of b to its key k, whose callbacks take turns with
+// those of b. Caps, probes and the dependency graph stay with b, which admits every call of its keys
+func (b *Breaker) inherit(k *Breaker) {
+	k.timeout, k.warmup, k.dryRun = b.timeout, b.warmup, b.dryRun
+	k.failureThreshold, k.successThreshold, k.tripOn = b.failureThreshold, b.successThreshold, b.tripOn
+	k.failurePredicate, k.classifier, k.halfOpenTimeout = b.failurePredicate, b.classifier, b.halfOpenTimeout
+	if b.adaptive != nil {
+		// Latencies are averaged by key
+		k.adaptive = &adaptive{multiplier: b.adaptive.multiplier, min: b.adaptive.min, max: b.adaptive.max}
+	}
+	k.clock, k.noLogging, k.logLevels, k.logSampling = b.clock, b.noLogging, b.logLevels, b.logSampling
+	// Events of a key go to the stream of the breaker
+	k.recorder, k.events = b.recorder, b.events
+	k.serial = b.serial
+}
+
+// shutdownKeys shuts down the breakers of all keys
+func (b *Breaker) shutdownKeys() {
+	b.keysMu.Lock()
+	defer b.keysMu.Unlock()
+	for _, k := range b.keys {
+		k.Shutdown()
+	}
+}
+
+// keyLimiter never rejects, a key relies on the capacity of its breaker
+type keyLimiter struct {
+	inFlight int64
+}
+
+func (l *keyLimiter) Acquire(ctx context.Context) bool {
+	atomic.AddInt64(&l.inFlight, 1)
+	return true
+}
+
+func (l *keyLimiter) Release()        { atomic.AddInt64(&l.inFlight, -1) }
+func (l *keyLimiter) InFlight() int   { return int(atomic.LoadInt64(&l.inFlight)) }
+func (l *keyLimiter) Available() bool { return true }
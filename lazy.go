@@ -0,0 +1,36 @@
+package breaker
+
+// WithLazyRecovery does away with the healthcheck goroutine, for breakers that are rarely used. A
+// tripped circuit is instead probed by the first call submitted once HealthCheckInterval has elapsed
+// since it opened or was last probed, on the goroutine of that call, and WithTTL is enforced by calls
+// as well. A circuit nobody calls is never repaired, which does not matter as nobody calls it
+func WithLazyRecovery() Option {
+	return func(b *Breaker) { b.lazy = true }
+}
+
+// lazyRecovery does the work of the healthcheck goroutine when a call is submitted, see WithLazyRecovery
+func (b *Breaker) lazyRecovery() {
+	if !b.lazy {
+		return
+	}
+	select {
+	case <-b.closing:
+		// Closed or shut down, no more repairs
+		return
+	default:
+	}
+	if b.ttl > 0 && b.since(b.started) >= b.ttl {
+		b.Shutdown()
+		return
+	}
+	interval := b.healthCheckInterval()
+	b.mu.Lock()
+	due := b.state() == StateOpen && b.since(b.lastProbe) >= interval
+	if due {
+		b.lastProbe = b.clock.Now()
+	}
+	b.mu.Unlock()
+	if due {
+		b.probe()
+	}
+}
@@ -0,0 +1,37 @@
+package breaker
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithLeakDetection warns, as an EventLeak, about results that were delivered but still not read
+// after timeout, pointing at callers that drop the channel returned by Execute and ignore errors.
+// Detection relies on garbage collection, the warning comes with the first collection after the
+// timeout. Meant for debugging, it costs a finalizer and a timer per call. Defaults to 0, off
+func WithLeakDetection(timeout time.Duration) Option {
+	return func(b *Breaker) { b.leakTimeout = timeout }
+}
+
+// leakHandle is finalized once its result had the time to be read
+type leakHandle struct {
+	name   string
+	unread func() bool
+}
+
+// watchLeak checks, once timeout has elapsed and the handle is collected, that a delivered result was read
+func (b *Breaker) watchLeak(commands CommandFuncs, unread func() bool) {
+	if b == nil || b.leakTimeout <= 0 || commands == nil {
+		return
+	}
+	h := &leakHandle{name: commands.Name(), unread: unread}
+	runtime.SetFinalizer(h, func(h *leakHandle) {
+		if h.unread() {
+			b.logEvent(EventLeak, logrus.Fields{"command": h.name}, "result never read")
+		}
+	})
+	// Keeps the handle reachable until the timeout
+	time.AfterFunc(b.leakTimeout, func() { runtime.KeepAlive(h) })
+}
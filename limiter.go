@@ -0,0 +1,40 @@
+package breaker
+
+import "context"
+
+// Limiter controls admission of tasks into the breaker, implemented by clients that need
+// a different admission policy than the default (fair queueing, shared resource pools)
+type Limiter interface {
+	Acquire(ctx context.Context) bool // Returns true if a token was obtained, caller must Release it
+	Release()                         // Returns a token obtained by Acquire
+	InFlight() int                    // Number of tokens currently held
+	Available() bool                  // Whether Acquire would obtain a token now, see Breaker.Allowed
+}
+
+// chanLimiter is the default Limiter, a buffered channel used as a non-blocking semaphore
+type chanLimiter struct {
+	semaphore chan bool
+}
+
+// NewLimiter returns the default Limiter, rejecting acquisitions beyond size tokens in flight. Meant
+// to be shared by breakers with WithLimiter
+func NewLimiter(size int) Limiter {
+	return newChanLimiter(size)
+}
+
+func newChanLimiter(size int) *chanLimiter {
+	return &chanLimiter{semaphore: make(chan bool, size)}
+}
+
+func (l *chanLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case l.semaphore <- true:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *chanLimiter) Release()        { <-l.semaphore }
+func (l *chanLimiter) InFlight() int   { return len(l.semaphore) }
+func (l *chanLimiter) Available() bool { return len(l.semaphore) < cap(l.semaphore) }
@@ -0,0 +1,402 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// loggingLimiter wraps the default limiter and records every acquisition
+type loggingLimiter struct {
+	mu       sync.Mutex
+	inner    *chanLimiter
+	acquired []bool
+}
+
+func (l *loggingLimiter) Acquire(ctx context.Context) bool {
+	ok := l.inner.Acquire(ctx)
+	l.mu.Lock()
+	l.acquired = append(l.acquired, ok)
+	l.mu.Unlock()
+	return ok
+}
+func (l *loggingLimiter) Release()        { l.inner.Release() }
+func (l *loggingLimiter) InFlight() int   { return l.inner.InFlight() }
+func (l *loggingLimiter) Available() bool { return l.inner.Available() }
+
+func (l *loggingLimiter) log() []bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]bool(nil), l.acquired...)
+}
+
+func Test_custom_limiter(t *testing.T) {
+	l := &loggingLimiter{inner: newChanLimiter(1)}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l))
+	b.SetHealthCheckInterval(1000 * time.Millisecond)
+	defer b.Shutdown()
+	err := <-b.Execute(&wrapper3{})
+	if !err.Success() {
+		t.Errorf("Was expecting success, instead got %v", err)
+	}
+	acquired := l.log()
+	if len(acquired) != 1 || !acquired[0] {
+		t.Errorf("Was expecting one successful acquisition, instead got %v", acquired)
+	}
+	if l.InFlight() != 0 {
+		t.Errorf("Token should have been released, in flight %d", l.InFlight())
+	}
+}
+
+func Test_default_limiter(t *testing.T) {
+	l := newChanLimiter(1)
+	if !l.Acquire(context.Background()) {
+		t.Errorf("First acquire should succeed")
+	}
+	if l.Acquire(context.Background()) {
+		t.Errorf("Second acquire should fail, limiter is full")
+	}
+	if l.InFlight() != 1 {
+		t.Errorf("Was expecting 1 in flight, instead got %d", l.InFlight())
+	}
+	l.Release()
+	if l.InFlight() != 0 {
+		t.Errorf("Was expecting 0 in flight, instead got %d", l.InFlight())
+	}
+}
+
+// panicLimiter fails on every acquisition
+type panicLimiter struct{}
+
+func (panicLimiter) Acquire(ctx context.Context) bool { panic("limiter is broken") }
+func (panicLimiter) Release()                         {}
+func (panicLimiter) InFlight() int                    { return 0 }
+func (panicLimiter) Available() bool                  { return false }
+
+func Test_fail_closed_rejects(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(panicLimiter{}))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &wrapper{}
+	err := <-b.Execute(w)
+	if err.Success() || w.exec {
+		t.Errorf("Fail closed should have rejected the command")
+	}
+}
+
+func Test_fail_open_runs(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(panicLimiter{}), WithFailMode(FailOpen))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &wrapper{}
+	err := <-b.Execute(w)
+	if !err.Success() || !w.exec {
+		t.Errorf("Fail open should have run the command, instead got %v", err)
+	}
+}
+
+func Test_nil_command_rejected(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithFailMode(FailOpen))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if err := <-b.Execute(nil); err.Success() || err.Err == nil {
+		t.Errorf("Nil command should have been rejected")
+	}
+}
+
+func Test_weighted_admission(t *testing.T) {
+	b := New("name", time.Second, 3)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	ch := b.Execute(w, WithWeight(2))
+	if !waitFor(func() bool { return b.limiter.InFlight() == 2 }) {
+		t.Fatalf("Weighted call should hold 2 tokens, instead holds %d", b.limiter.InFlight())
+	}
+	if err := <-b.Execute(&wrapper3{}, WithWeight(2)); err.Success() {
+		t.Errorf("Second weighted call should have been rejected")
+	}
+	if b.limiter.InFlight() != 2 {
+		t.Errorf("Rejected call should not hold tokens, in flight %d", b.limiter.InFlight())
+	}
+	close(w.release)
+	<-ch
+	if !waitFor(func() bool { return b.limiter.InFlight() == 0 }) {
+		t.Errorf("All tokens should be released, in flight %d", b.limiter.InFlight())
+	}
+}
+
+func Test_weight_exceeding_capacity(t *testing.T) {
+	b := New("name", time.Second, 3)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if err := <-b.Execute(&wrapper3{}, WithWeight(4)); err.Success() {
+		t.Errorf("Call heavier than capacity should have been rejected")
+	}
+	if b.State() != StateClosed {
+		t.Errorf("Overweight call should not trip the circuit, instead got %v", b.State())
+	}
+	if b.limiter.InFlight() != 0 {
+		t.Errorf("Rejected call should not hold tokens, in flight %d", b.limiter.InFlight())
+	}
+}
+
+// slowLimiter makes every acquisition wait, as a queueing limiter would under contention
+type slowLimiter struct {
+	chanLimiter
+	wait time.Duration
+}
+
+func (l *slowLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case <-time.After(l.wait):
+	case <-ctx.Done():
+		return false
+	}
+	return l.chanLimiter.Acquire(ctx)
+}
+
+// sleeper sleeps for d
+type sleeper struct {
+	d time.Duration
+}
+
+func (w *sleeper) CommandFunc() { time.Sleep(w.d) }
+func (w *sleeper) DefaultFunc() {}
+func (w *sleeper) CleanupFunc() {}
+func (w *sleeper) Name() string { return "sleeper" }
+
+func Test_total_budget_includes_queue_wait(t *testing.T) {
+	l := &slowLimiter{chanLimiter: *newChanLimiter(1), wait: 50 * time.Millisecond}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l), WithTotalBudget(80*time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	err := <-b.Execute(&sleeper{d: 50 * time.Millisecond})
+	if !err.Timeout() {
+		t.Errorf("Queue wait should have shortened the command deadline, instead got %v", err)
+	}
+
+	b2 := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(&slowLimiter{chanLimiter: *newChanLimiter(1), wait: 50 * time.Millisecond}))
+	b2.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b2.Shutdown()
+	if err := <-b2.Execute(&sleeper{d: 50 * time.Millisecond}); !err.Success() {
+		t.Errorf("Without a budget the command should succeed, instead got %v", err)
+	}
+}
+
+func Test_token_released_on_timeout(t *testing.T) {
+	w := &blocker{release: make(chan bool)}
+	// Command keeps running, it is only released at the end of the test
+	defer close(w.release)
+	var abandoned CommandFuncs
+	b := NewWithOptions("name", WithTimeout(time.Millisecond), WithConcurrency(1),
+		WithTimeoutReleasesResources(func(commands CommandFuncs) { abandoned = commands }))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if err := <-b.Execute(w); !err.Timeout() {
+		t.Errorf("Was expecting a timeout, instead got %v", err)
+	}
+	if abandoned != w {
+		t.Errorf("Was expecting the timed out command to be abandoned, instead got %v", abandoned)
+	}
+	if !waitFor(func() bool { return b.limiter.InFlight() == 0 }) {
+		t.Errorf("Was expecting the token to be released on timeout, instead got %d in flight", b.limiter.InFlight())
+	}
+}
+
+func Test_cleanup_on_rejection(t *testing.T) {
+	for _, cleanup := range []bool{true, false} {
+		b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithCleanupOnRejection(cleanup))
+		b.SetHealthCheckInterval(100000 * time.Millisecond)
+		b.trip("test")
+		w := &counter{}
+		if err := <-b.Execute(w); err.Reason() != ReasonOpen {
+			t.Errorf("Was expecting rejection, instead got %v", err)
+		}
+		want := int32(0)
+		if cleanup {
+			want = 1
+		}
+		if atomic.LoadInt32(&w.defaults) != 1 || atomic.LoadInt32(&w.cleanups) != want {
+			t.Errorf("With cleanup %v was expecting 1 default and %d cleanups, instead got %d and %d",
+				cleanup, want, w.defaults, w.cleanups)
+		}
+		b.Shutdown()
+	}
+}
+
+func Test_shutdown_while_waiting_for_admission(t *testing.T) {
+	l := &slowLimiter{chanLimiter: *newChanLimiter(1), wait: 30 * time.Millisecond}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	w := &counter{}
+	ran := int32(0)
+	ch := b.Execute(&running{counter: w, ran: &ran})
+	b.Shutdown()
+	if err := <-ch; !err.Shutdown() || err.Reason() != ReasonShutdown {
+		t.Errorf("Was expecting a shutdown error, instead got %v", err)
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Errorf("Command should not run after shutdown")
+	}
+	if !waitFor(func() bool { return l.InFlight() == 0 }) {
+		t.Errorf("Token should have been released, instead got %d in flight", l.InFlight())
+	}
+}
+
+func Test_max_queue_items(t *testing.T) {
+	l := &slowLimiter{chanLimiter: *newChanLimiter(10), wait: 50 * time.Millisecond}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l), WithMaxQueueItems(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var chs []chan Error
+	for i := 0; i < 10; i++ {
+		chs = append(chs, b.Execute(&wrapper3{}))
+	}
+	rejected := 0
+	for _, ch := range chs {
+		if err := <-ch; err.Reason() == ReasonSaturated {
+			rejected++
+		}
+	}
+	if rejected != 8 {
+		t.Errorf("Was expecting 8 rejections beyond the queue cap, instead got %d", rejected)
+	}
+	if err := <-b.Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Drained queue should admit again, instead got %v", err)
+	}
+}
+
+// blockingLimiter queues acquisitions until a token is free or ctx is done
+type blockingLimiter struct {
+	semaphore chan bool
+}
+
+func (l *blockingLimiter) Acquire(ctx context.Context) bool {
+	select {
+	case l.semaphore <- true:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *blockingLimiter) Release()        { <-l.semaphore }
+func (l *blockingLimiter) InFlight() int   { return len(l.semaphore) }
+func (l *blockingLimiter) Available() bool { return len(l.semaphore) < cap(l.semaphore) }
+
+// gauge tracks the peak of concurrently running commands
+type gauge struct {
+	mu      sync.Mutex
+	current int
+	peak    int
+}
+
+func (g *gauge) add(n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current += n
+	if g.current > g.peak {
+		g.peak = g.current
+	}
+}
+
+// gauged holds its token for a while, counted by the gauge
+type gauged struct {
+	g *gauge
+}
+
+func (w *gauged) CommandFunc() {
+	w.g.add(1)
+	time.Sleep(5 * time.Millisecond)
+	w.g.add(-1)
+}
+func (w *gauged) DefaultFunc() {}
+func (w *gauged) CleanupFunc() {}
+func (w *gauged) Name() string { return "gauged" }
+
+func Test_shared_limiter(t *testing.T) {
+	l := NewLimiter(2)
+	b1 := NewWithOptions("one", WithTimeout(time.Second), WithLimiter(l), WithRecoveryPolicy(CapacityAvailable))
+	b2 := NewWithOptions("two", WithTimeout(time.Second), WithLimiter(l), WithRecoveryPolicy(CapacityAvailable))
+	b1.SetHealthCheckInterval(1 * time.Millisecond)
+	b2.SetHealthCheckInterval(1 * time.Millisecond)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	g := &gauge{}
+	var chs []chan Error
+	for i := 0; i < 20; i++ {
+		chs = append(chs, b1.Execute(&gauged{g}), b2.Execute(&gauged{g}))
+	}
+	admitted := 0
+	for err := range Collect(chs...) {
+		if err.Success() {
+			admitted++
+		}
+	}
+	if g.peak > 2 || admitted == 0 {
+		t.Errorf("Was expecting at most 2 commands running across breakers, instead got peak %d with %d admitted", g.peak, admitted)
+	}
+	if !waitFor(func() bool { return l.InFlight() == 0 }) {
+		t.Errorf("Was expecting all tokens back, instead got %d", l.InFlight())
+	}
+}
+
+func Test_canceled_waiter_leaves_queue(t *testing.T) {
+	l := &blockingLimiter{semaphore: make(chan bool, 1)}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l), WithFailureThreshold(1))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	first := b.Execute(w)
+	if !waitFor(func() bool { return b.Running() == 1 }) {
+		t.Fatalf("Was expecting the first call to run")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran int32
+	queued := b.ExecuteContext(ctx, &running{counter: &counter{}, ran: &ran})
+	if !waitFor(func() bool { return b.Queued() == 1 }) {
+		t.Fatalf("Was expecting the second call to queue")
+	}
+	cancel()
+	err := <-queued
+	if err.Reason() != ReasonCanceled || b.Queued() != 0 {
+		t.Errorf("Was expecting the canceled call to leave the queue, instead got %v and %d queued", err, b.Queued())
+	}
+	if got := b.Stats().Count(OutcomeIgnored); got != 1 {
+		t.Errorf("Was expecting the canceled call to be ignored, instead got %d ignored", got)
+	}
+	if b.State() != StateClosed {
+		t.Errorf("Canceled waiter should not trip the circuit, instead got %v", b.State())
+	}
+	close(w.release)
+	<-first
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Errorf("Canceled call should not have run")
+	}
+	if b.State() != StateClosed {
+		t.Errorf("Canceled waiter should not trip the circuit, instead got %v", b.State())
+	}
+}
+
+func Test_queue_and_service_durations(t *testing.T) {
+	l := &blockingLimiter{semaphore: make(chan bool, 1)}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	first := b.Execute(&sleeper{d: 30 * time.Millisecond})
+	if !waitFor(func() bool { return b.Running() == 1 }) {
+		t.Fatalf("Was expecting the first call to run")
+	}
+	second := b.Execute(&sleeper{d: 10 * time.Millisecond})
+	<-first
+	err := <-second
+	if q := err.QueueDuration(); q < 15*time.Millisecond || q > 500*time.Millisecond {
+		t.Errorf("Was expecting the second call to queue behind the first, instead got %v", q)
+	}
+	if s := err.ServiceDuration(); s < 10*time.Millisecond || s > 500*time.Millisecond {
+		t.Errorf("Was expecting the second call to run 10ms, instead got %v", s)
+	}
+}
@@ -0,0 +1,85 @@
+package breaker
+
+import (
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventType identifies what a breaker is logging, used to set log levels per event
+type EventType int
+
+const (
+	EventTransition EventType = iota // Circuit changed state
+	EventRecovery                    // Healthcheck attempted to repair the circuit
+	EventRejection                   // Call was not admitted
+	EventTimeout                     // Command timed out
+	EventCanceled                    // Context of a call was done before the command completed
+	EventPanic                       // Command panicked
+	EventInternal                    // Breaker itself failed or was misused
+	EventSuccess                     // Command completed successfully
+	EventFailure                     // Command returned an error
+	EventLeak                        // Result of a call was never read, see WithLeakDetection
+	numEventTypes
+)
+
+// defaultLogLevels keeps high frequency events quiet
+var defaultLogLevels = map[EventType]logrus.Level{
+	EventTransition: logrus.WarnLevel,
+	EventRecovery:   logrus.InfoLevel,
+	EventRejection:  logrus.DebugLevel,
+	EventTimeout:    logrus.DebugLevel,
+	EventCanceled:   logrus.DebugLevel,
+	EventPanic:      logrus.ErrorLevel,
+	EventInternal:   logrus.ErrorLevel,
+	EventSuccess:    logrus.TraceLevel,
+	EventFailure:    logrus.DebugLevel,
+	EventLeak:       logrus.WarnLevel,
+}
+
+// sampled are the repetitive events subject to WithLogSampling
+var sampled = map[EventType]bool{
+	EventRejection: true,
+	EventTimeout:   true,
+	EventCanceled:  true,
+	EventSuccess:   true,
+	EventFailure:   true,
+}
+
+// WithNoLogging silences the breaker, for libraries embedding it that do their own logging, for
+// instance from OnStateChange and OnComplete. An EventRecorder still captures every event
+func WithNoLogging() Option {
+	return func(b *Breaker) { b.noLogging = true }
+}
+
+// logEvent logs msg at the level configured for event and hands it to the EventRecorder
+func (b *Breaker) logEvent(event EventType, fields logrus.Fields, msg string) {
+	if b.recorder != nil {
+		b.recorder.add(Event{Time: b.clock.Now(), Type: event, Message: msg, Fields: fields})
+	}
+	if b.events != nil {
+		// Nothing follows the transition to shutdown
+		last := event == EventTransition && fields["to"] == StateShutdown
+		b.events.send(Event{Time: b.clock.Now(), Type: event, Message: msg, Fields: fields}, last)
+	}
+	if b.noLogging {
+		return
+	}
+	if b.logSampling > 1 && sampled[event] {
+		if n := atomic.AddUint64(&b.logCounts[event], 1); (n-1)%uint64(b.logSampling) != 0 {
+			return
+		}
+	}
+	level, ok := b.logLevels[event]
+	if !ok {
+		level = defaultLogLevels[event]
+	}
+	if !b.log.IsLevelEnabled(level) {
+		return
+	}
+	entry := b.log.WithField("name", b.name)
+	if fields != nil {
+		entry = entry.WithFields(fields)
+	}
+	entry.Log(level, msg)
+}
@@ -0,0 +1,179 @@
+package breaker
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// levelOf returns the level of the first entry logged with msg
+func levelOf(hook *test.Hook, msg string) (logrus.Level, bool) {
+	for _, e := range hook.AllEntries() {
+		if e.Message == msg {
+			return e.Level, true
+		}
+	}
+	return 0, false
+}
+
+func Test_log_levels(t *testing.T) {
+	levels := map[EventType]logrus.Level{EventRejection: logrus.WarnLevel}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(0), WithLogLevels(levels))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.log.SetLevel(logrus.TraceLevel)
+	hook := test.NewLocal(b.log)
+	<-b.Execute(&wrapper3{})
+	if level, ok := levelOf(hook, "task rejected"); !ok || level != logrus.WarnLevel {
+		t.Errorf("Rejection should log at configured warn level, got %v %v", level, ok)
+	}
+	if level, ok := levelOf(hook, "circuit changed state"); !ok || level != logrus.WarnLevel {
+		t.Errorf("Transition should log at default warn level, got %v %v", level, ok)
+	}
+}
+
+func Test_default_log_levels_are_quiet(t *testing.T) {
+	b := New("name", time.Second, 0)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	hook := test.NewLocal(b.log)
+	<-b.Execute(&wrapper3{})
+	if _, ok := levelOf(hook, "task rejected"); ok {
+		t.Errorf("Rejections should not be logged at the default info level")
+	}
+}
+
+func Test_labels(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Millisecond), WithConcurrency(1))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.log.SetLevel(logrus.TraceLevel)
+	hook := test.NewLocal(b.log)
+	labels := map[string]string{"region": "east", "tenant": "acme"}
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	r := <-b.ExecuteResult(context.Background(), w, WithLabels(labels))
+	if r.Labels["region"] != "east" || r.Labels["tenant"] != "acme" {
+		t.Errorf("Was expecting labels on the result, instead got %v", r.Labels)
+	}
+	for _, e := range hook.AllEntries() {
+		if e.Message == "task timed out" {
+			if e.Data["region"] != "east" || e.Data["tenant"] != "acme" {
+				t.Errorf("Was expecting labels on the log entry, instead got %v", e.Data)
+			}
+			return
+		}
+	}
+	t.Errorf("Was expecting a timeout to be logged")
+}
+
+func Test_log_sampling(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(0), WithLogSampling(10))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.log.SetLevel(logrus.TraceLevel)
+	hook := test.NewLocal(b.log)
+	for i := 0; i < 100; i++ {
+		<-b.Execute(&wrapper3{})
+	}
+	rejections, transitions := 0, 0
+	for _, e := range hook.AllEntries() {
+		switch e.Message {
+		case "task rejected":
+			rejections++
+		case "circuit changed state":
+			transitions++
+		}
+	}
+	if rejections != 10 {
+		t.Errorf("Was expecting 10 sampled rejections, instead got %d", rejections)
+	}
+	if transitions != 1 {
+		t.Errorf("Was expecting the transition to be logged, instead got %d", transitions)
+	}
+}
+
+func Test_leak_detection(t *testing.T) {
+	recorder := &EventRecorder{}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(2), WithEventRecorder(recorder), WithLeakDetection(time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	<-b.Execute(&wrapper3{})
+	ignored := b.Execute(&counter{})
+	if !waitFor(func() bool { return len(ignored) == 1 }) {
+		t.Fatalf("Was expecting a result")
+	}
+	leaks := 0
+	waitFor(func() bool {
+		runtime.GC()
+		for _, e := range recorder.Drain() {
+			if e.Type == EventLeak {
+				leaks++
+				if e.Fields["command"] != "counter" {
+					t.Errorf("Was expecting the leak of counter, instead got %v", e.Fields["command"])
+				}
+			}
+		}
+		return leaks > 0
+	})
+	if leaks != 1 {
+		t.Errorf("Was expecting 1 leak warning, instead got %d", leaks)
+	}
+}
+
+func Test_no_logging(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = w, w
+	b := NewWithOptions("name", WithTimeout(time.Millisecond), WithConcurrency(1), WithNoLogging(), WithFailureThreshold(1))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	blocked := &blocker{release: make(chan bool)}
+	<-b.Execute(blocked)
+	<-b.Execute(&panicker{})
+	b.triggerHealthCheck()
+	<-b.Execute(&counter{})
+	b.Shutdown()
+	close(blocked.release)
+	os.Stdout, os.Stderr = stdout, stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if len(out) != 0 {
+		t.Errorf("Was expecting no output, instead got %s", out)
+	}
+}
+
+func Test_events_closed_on_shutdown(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithEvents(100))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	done := make(chan []Event)
+	go func() {
+		var got []Event
+		for e := range b.Events() {
+			got = append(got, e)
+		}
+		done <- got
+	}()
+	for i := 0; i < 10; i++ {
+		go b.Execute(&counter{})
+	}
+	b.trip("test")
+	b.Shutdown()
+	select {
+	case got := <-done:
+		last := got[len(got)-1]
+		if last.Type != EventTransition || last.Fields["to"] != StateShutdown {
+			t.Errorf("Was expecting the transition to shutdown last, instead got %+v", last)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Was expecting the range over Events to end after Shutdown")
+	}
+}
@@ -0,0 +1,194 @@
+package breaker
+
+import "github.com/sirupsen/logrus"
+
+// machine is the part of the breaker state driven by step
+type machine struct {
+	state     State
+	failures  int  // Consecutive failed calls
+	trial     bool // Trial call is in flight while half open
+	successes int  // Successful trials while half open
+	forced    bool // Pinned open by ForceOpen, only counters move until ClearOverride
+}
+
+type eventKind int
+
+const (
+	eventCall      eventKind = iota // A call ran, failed tells whether it counts as a failure
+	eventRejection                  // A call was rejected, failed tells whether it counts as a failure
+	eventIgnored                    // A call was abandoned by its client
+	eventSaturated                  // A call found no capacity
+	eventTrial                      // A call asks to be the trial of a half open circuit
+	eventTick                       // Healthcheck found the circuit open, without a probe or recovery policy
+	eventProbe                      // Probe, background probe or recovery policy ran, failed tells whether the circuit is still bad
+	eventShutdown                   // Client shut the circuit down
+	eventTrip                       // Circuit opened for reason, such as a failed startup probe
+	eventForce                      // Client pinned the circuit open, see ForceOpen
+	eventClear                      // Client ended ForceOpen, see ClearOverride
+	eventAmend                      // A failure was amended away, tripped tells whether it opened the circuit
+	numEventKinds
+)
+
+// event is the input of step
+type event struct {
+	kind    eventKind
+	failed  bool
+	tripped bool   // See eventAmend
+	reason  string // See eventTrip
+}
+
+// stepConfig is the configuration step depends on
+type stepConfig struct {
+	failureThreshold int  // Consecutive failures that trip the circuit, 0 means never
+	successThreshold int  // Successful trials that close a half open circuit, 0 means 1
+	warmingUp        bool // Circuit never trips while warming up
+}
+
+// step returns the state following m on ev and the reason of a state change. It has no side effects,
+// the breaker applies its result under lock
+func step(m machine, ev event, cfg stepConfig) (machine, string) {
+	if m.state == StateShutdown {
+		if ev.kind == eventClear {
+			m.forced = false
+		}
+		return m, ""
+	}
+	next, reason := advance(m, ev, cfg)
+	if m.forced && ev.kind != eventShutdown && ev.kind != eventClear {
+		// Pinned open, only counters move
+		next.state, next.trial, next.forced, reason = m.state, m.trial, true, ""
+	}
+	return next, reason
+}
+
+// advance is step regardless of ForceOpen
+func advance(m machine, ev event, cfg stepConfig) (machine, string) {
+	switch ev.kind {
+	case eventShutdown:
+		return machine{state: StateShutdown, failures: m.failures, forced: m.forced}, "shutdown"
+	case eventTrip:
+		if m.state != StateOpen {
+			return machine{state: StateOpen, failures: m.failures}, ev.reason
+		}
+	case eventForce:
+		if m.state != StateOpen {
+			return machine{state: StateOpen, failures: m.failures, forced: true}, "forced open"
+		}
+		m.forced = true
+	case eventClear:
+		if m.forced {
+			// Counters went stale during the override, the next calls are trials
+			return machine{state: StateHalfOpen}, "override cleared"
+		}
+	case eventAmend:
+		if ev.tripped && m.state == StateOpen {
+			return machine{state: StateClosed}, "amended"
+		}
+		if m.failures > 0 {
+			m.failures--
+		}
+	case eventIgnored:
+		m.trial = false
+	case eventSaturated:
+		if m.state != StateOpen && !cfg.warmingUp {
+			return machine{state: StateOpen, failures: m.failures}, "reached threshold"
+		}
+	case eventTrial:
+		if m.state == StateHalfOpen {
+			m.trial = true
+		}
+	case eventTick:
+		if m.state == StateOpen {
+			return machine{state: StateHalfOpen, failures: m.failures}, "awaiting trial"
+		}
+	case eventProbe:
+		if m.state == StateOpen && !ev.failed {
+			return machine{state: StateClosed}, "repaired"
+		}
+		if m.state == StateHalfOpen && !ev.failed {
+			return machine{state: StateClosed}, "background probe succeeded"
+		}
+	case eventRejection, eventCall:
+		if m.state == StateHalfOpen {
+			if ev.kind == eventRejection {
+				// Rejections tell nothing about the downstream
+				return m, ""
+			}
+			if ev.failed {
+				return machine{state: StateOpen, failures: m.failures + 1}, "trial failed"
+			}
+			m.trial = false
+			m.successes++
+			if m.successes < cfg.successThreshold {
+				return m, ""
+			}
+			return machine{state: StateClosed}, "trial succeeded"
+		}
+		if !ev.failed {
+			m.failures = 0
+			return m, ""
+		}
+		m.failures++
+		if m.state == StateClosed && cfg.failureThreshold > 0 && m.failures >= cfg.failureThreshold && !cfg.warmingUp {
+			m.state = StateOpen
+			return m, "failure threshold"
+		}
+	}
+	return m, ""
+}
+
+// machine must be called with mu held
+func (b *Breaker) machine() machine {
+	return machine{state: b.state(), failures: b.failures, trial: b.trial, successes: b.successes, forced: b.forced}
+}
+
+// setMachine must be called with mu held
+func (b *Breaker) setMachine(m machine) {
+	b.isShutdown = m.state == StateShutdown
+	b.isOk = m.state == StateClosed
+	b.halfOpen = m.state == StateHalfOpen
+	b.failures = m.failures
+	b.trial = m.trial
+	b.successes = m.successes
+	b.forced = m.forced
+	switch m.state {
+	case StateClosed:
+		b.status = iCircuitGood
+	case StateShutdown:
+		b.status = iShutdown
+	default:
+		b.status = iCircuitStillBad
+	}
+}
+
+// apply drives the breaker with ev, returns true if the state changed
+func (b *Breaker) apply(ev event) bool {
+	from, to := b.drive(ev)
+	return from.state != to.state
+}
+
+// drive steps the breaker with ev under mu and reports a change of state once mu is released. Returns
+// the machine before and after ev
+func (b *Breaker) drive(ev event) (from, to machine) {
+	cfg := stepConfig{failureThreshold: b.failureThreshold, successThreshold: b.successThreshold, warmingUp: b.warmingUp()}
+	if len(b.recoverySteps) > 0 {
+		cfg.successThreshold = len(b.recoverySteps)
+	}
+	b.mu.Lock()
+	from = b.machine()
+	to, reason := step(from, ev, cfg)
+	b.setMachine(to)
+	b.mu.Unlock()
+	b.changed(from.state, to.state, reason)
+	return from, to
+}
+
+// changed logs and notifies a change of state, returns false if there was none
+func (b *Breaker) changed(from, to State, reason string) bool {
+	if from == to {
+		return false
+	}
+	b.logEvent(EventTransition, logrus.Fields{"from": from, "to": to, "reason": reason}, "circuit changed state")
+	b.notifyStateChange(from, to, reason)
+	return true
+}
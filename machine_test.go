@@ -0,0 +1,114 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_step_trial(t *testing.T) {
+	m := machine{state: StateOpen}
+	m, _ = step(m, event{kind: eventTick}, stepConfig{})
+	if m.state != StateHalfOpen {
+		t.Fatalf("Tick should move open circuit to half open, got %v", m.state)
+	}
+	m, _ = step(m, event{kind: eventTrial}, stepConfig{})
+	if !m.trial {
+		t.Fatalf("Trial should be in flight")
+	}
+	m, reason := step(m, event{kind: eventCall, failed: true}, stepConfig{})
+	if m.state != StateOpen || m.trial || reason != "trial failed" {
+		t.Errorf("Failed trial should reopen, got %+v %q", m, reason)
+	}
+}
+
+func Test_step_force_clear(t *testing.T) {
+	m, reason := step(machine{state: StateClosed, failures: 1}, event{kind: eventForce}, stepConfig{})
+	if m.state != StateOpen || !m.forced || m.failures != 1 || reason != "forced open" {
+		t.Fatalf("Force should pin the circuit open, got %+v %q", m, reason)
+	}
+	m, _ = step(m, event{kind: eventProbe}, stepConfig{})
+	m, _ = step(m, event{kind: eventAmend, tripped: true}, stepConfig{})
+	if m.state != StateOpen || !m.forced {
+		t.Fatalf("Forced circuit should stay open, got %+v", m)
+	}
+	m, reason = step(m, event{kind: eventClear}, stepConfig{})
+	if m != (machine{state: StateHalfOpen}) || reason != "override cleared" {
+		t.Errorf("Clear should start over half open, got %+v %q", m, reason)
+	}
+}
+
+func FuzzStateMachine(f *testing.F) {
+	f.Add(uint8(2), false, []byte{0, 0, 4, 20, 16, 0})
+	f.Add(uint8(0), true, []byte{12, 20, 16, 4, 28, 0})
+	f.Add(uint8(1), false, []byte{4, 20, 16, 12, 8, 24, 28, 4})
+	f.Add(uint8(2), false, []byte{1, 1, 46, 36, 1, 40, 24, 40, 32})
+	f.Fuzz(func(t *testing.T, threshold uint8, warmingUp bool, events []byte) {
+		cfg := stepConfig{failureThreshold: int(threshold % 8), successThreshold: int(threshold / 8 % 4), warmingUp: warmingUp}
+		m := machine{state: StateClosed}
+		for _, e := range events {
+			ev := event{kind: eventKind(int(e>>2) % int(numEventKinds)), failed: e&1 != 0, tripped: e&2 != 0, reason: "fuzz"}
+			next, reason := step(m, ev, cfg)
+			if m.state == StateShutdown && (next.state != m.state || next.failures != m.failures) {
+				t.Fatalf("Shutdown circuit changed to %+v on %+v", next, ev)
+			}
+			if next.state < StateClosed || next.state > StateShutdown {
+				t.Fatalf("Unknown state %v", next.state)
+			}
+			if next.trial && next.state != StateHalfOpen {
+				t.Fatalf("Trial in flight while %v", next.state)
+			}
+			if next.state == StateHalfOpen && m.state != StateOpen && m.state != StateHalfOpen {
+				t.Fatalf("Half open reached from %v", m.state)
+			}
+			if next.successes > 0 && next.state != StateHalfOpen {
+				t.Fatalf("Successful trials counted while %v", next.state)
+			}
+			if next.forced && next.state != StateOpen && next.state != StateShutdown {
+				t.Fatalf("Forced open while %v", next.state)
+			}
+			if m.forced && next.state != m.state && ev.kind != eventClear && ev.kind != eventShutdown {
+				t.Fatalf("Forced circuit changed to %v on %+v", next.state, ev)
+			}
+			if next.failures < 0 {
+				t.Fatalf("Negative failures %d", next.failures)
+			}
+			if next.state == StateClosed && cfg.failureThreshold > 0 && !cfg.warmingUp && next.failures >= cfg.failureThreshold {
+				t.Fatalf("Closed with %d failures, threshold %d", next.failures, cfg.failureThreshold)
+			}
+			if (next.state != m.state) != (reason != "") {
+				t.Fatalf("Reason %q does not match change %v to %v", reason, m.state, next.state)
+			}
+			m = next
+		}
+	})
+}
+
+func Test_success_threshold(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(2), WithSuccessThreshold(3))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	b.triggerHealthCheck()
+	if err := <-b.Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("First trial should succeed, instead got %v", err)
+	}
+	if b.State() != StateHalfOpen {
+		t.Errorf("One success should keep the circuit half open, instead got %v", b.State())
+	}
+	if err := <-b.Execute(&panicker{}); !err.Panic() {
+		t.Errorf("Second trial should fail, instead got %v", err)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Failed trial should reopen the circuit, instead got %v", b.State())
+	}
+	b.triggerHealthCheck()
+	for i := 0; i < 3; i++ {
+		if b.State() != StateHalfOpen {
+			t.Errorf("Was expecting half open before trial %d, instead got %v", i+1, b.State())
+		}
+		<-b.Execute(&wrapper3{})
+	}
+	if b.State() != StateClosed {
+		t.Errorf("Three successes should close the circuit, instead got %v", b.State())
+	}
+}
@@ -0,0 +1,330 @@
+package breaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Option configures a Breaker created by NewWithOptions
+type Option func(b *Breaker)
+
+// WithTimeout sets the breaker level timeout, can be overridden by clients implementing Timeout.
+// Calls with a timeout under a millisecond always time out, the command has no fair chance to complete
+func WithTimeout(timeout time.Duration) Option {
+	return func(b *Breaker) { b.timeout = timeout }
+}
+
+// WithConcurrency sets the number of tasks that can execute concurrently
+func WithConcurrency(numConcurrent int) Option {
+	return func(b *Breaker) { b.numConcurrent = numConcurrent }
+}
+
+// WithLimiter replaces the default channel based semaphore with a client provided Limiter. The same
+// Limiter may be given to several breakers drawing on a shared resource, such as a connection pool,
+// to cap their combined concurrency while each keeps its own trip logic. The breaker failing to
+// acquire a token trips, not its siblings
+func WithLimiter(l Limiter) Option {
+	return func(b *Breaker) { b.limiter = l }
+}
+
+// WithWarmup sets a period after start during which the circuit never trips, the concurrency
+// limit still applies
+func WithWarmup(d time.Duration) Option {
+	return func(b *Breaker) { b.warmup = d }
+}
+
+// withClock replaces the source of time, used by tests
+func withClock(c clock) Option {
+	return func(b *Breaker) { b.clock = c }
+}
+
+// WithMaxGoroutines caps the number of live goroutines spawned by Execute, new work is
+// rejected once the cap is reached. Protects against runaway growth from commands that
+// never return after timing out. A call takes two slots, one for itself and one for its
+// command, a hedge or speculative fallback is skipped when no slot is left for it
+func WithMaxGoroutines(n int) Option {
+	return func(b *Breaker) { b.maxGoroutines = int64(n) }
+}
+
+// WithProbe sets a lightweight health check run by the healthcheck goroutine while the circuit
+// is open, instead of risking real traffic. The circuit closes only when probe returns nil
+func WithProbe(probe func() error) Option {
+	return func(b *Breaker) { b.probeFunc = probe }
+}
+
+// WithStartupProbe runs probe before the breaker is returned, the circuit starts open if it fails so
+// no traffic reaches a dependency known to be down. The circuit is then repaired as after any trip
+func WithStartupProbe(probe func() error) Option {
+	return func(b *Breaker) { b.startupProbe = probe }
+}
+
+// WithClassifier decides the outcome of calls whose context is done before the command completes,
+// defaults to DefaultClassifier
+func WithClassifier(classifier func(err error) Outcome) Option {
+	return func(b *Breaker) { b.classifier = classifier }
+}
+
+// WithTransitionHistory sets how many recent transitions are kept for Transitions, defaults to 10
+func WithTransitionHistory(n int) Option {
+	return func(b *Breaker) { b.transitions = newTransitionLog(n) }
+}
+
+// withHealthCheckInterval sets HealthCheckInterval before the healthcheck goroutine starts
+func withHealthCheckInterval(d time.Duration) Option {
+	return func(b *Breaker) { b.HealthCheckInterval = d / time.Millisecond }
+}
+
+// WithFailMode sets the behavior when the breaker itself fails, defaults to FailClosed.
+// A nil command is always rejected
+func WithFailMode(mode FailMode) Option {
+	return func(b *Breaker) { b.failMode = mode }
+}
+
+// WithFailureThreshold trips the circuit after n consecutive failed calls, 0 (the default) leaves
+// tripping to saturation alone
+func WithFailureThreshold(n int) Option {
+	return func(b *Breaker) { b.failureThreshold = n }
+}
+
+// WithFailurePredicate decides which calls count as failures toward the failure threshold,
+// defaults to DefaultFailurePredicate
+func WithFailurePredicate(failed func(Error) bool) Option {
+	return func(b *Breaker) { b.failurePredicate = failed }
+}
+
+// WithSuccessThreshold requires n consecutive successful trials while half open to close the
+// circuit, a single failed trial reopens it. Trials are admitted one at a time. Defaults to 1
+func WithSuccessThreshold(n int) Option {
+	return func(b *Breaker) { b.successThreshold = n }
+}
+
+// WithTripOn selects the failed outcomes that count toward the failure threshold, such as
+// TripOnTimeouts|TripOnErrors to leave out rejections, which show the breaker working rather than
+// the dependency failing. Outcomes left out neither count as failures nor reset the count. Saturation
+// trips the circuit whatever the selection. Defaults to TripOnAll
+func WithTripOn(on TripOn) Option {
+	return func(b *Breaker) { b.tripOn = on }
+}
+
+// WithGradualRecovery replaces the single trial of a half open circuit with a ramp: calls are admitted
+// at random with the probability of the current step, such as 0.1, 0.5 then 1.0, each successful call
+// moving to the next step. The circuit closes once a call succeeds at the last step and reopens on any
+// failure. Overrides WithSuccessThreshold
+func WithGradualRecovery(steps []float64) Option {
+	return func(b *Breaker) { b.recoverySteps = steps }
+}
+
+// WithHalfOpenTimeout sets the timeout of the trial calls of a half open circuit, shorter to reopen
+// fast or longer to give the dependency time to recover. It replaces the timeout of the breaker, of
+// the command and of the call. Defaults to 0, trials use the normal timeout
+func WithHalfOpenTimeout(d time.Duration) Option {
+	return func(b *Breaker) { b.halfOpenTimeout = d }
+}
+
+// WithRecoveryPolicy decides whether a tripped circuit is repaired, consulted by the healthcheck
+// goroutine while the circuit is open. By default the circuit goes half open and a trial call decides
+func WithRecoveryPolicy(repaired func(*Breaker) bool) Option {
+	return func(b *Breaker) { b.recoveryPolicy = repaired }
+}
+
+// WithAdmissionFunc decides admission of every call from signals outside the breaker, such as tenant
+// quotas. It is consulted with the context and the name of the command before any token is taken, a
+// call is rejected with ReasonDenied and the returned error when it returns false. A denied call says
+// nothing about the downstream and is left out of the trip policy
+func WithAdmissionFunc(admit func(ctx context.Context, name string) (bool, error)) Option {
+	return func(b *Breaker) { b.admission = admit }
+}
+
+// WithTotalBudget bounds the time from submission to result, including time spent waiting for
+// admission by a blocking Limiter. The command timeout is shortened by the time already waited
+func WithTotalBudget(d time.Duration) Option {
+	return func(b *Breaker) { b.totalBudget = d }
+}
+
+// CallOption configures a single call to Execute
+type CallOption func(c *call)
+
+// call holds the settings of a single call to Execute
+type call struct {
+	weight         int               // Tokens consumed by the call
+	labels         map[string]string // Attached to the Result and logs of the call
+	timeout        time.Duration     // Overrides the timeout of the breaker and of the command
+	ticket         uint64            // Turn of the call with WithSerializedCallbacks
+	ticketed       bool              // Ticket taken and its turn not done yet
+	serial         *sequencer        // Sequencer the ticket is taken from, see WithSerializedCallbacks
+	observers      []*Breaker        // Breakers recording the outcome instead of the finishing one, see Composite
+	untokened      []*Breaker        // Breakers that admitted the call without tokens, see WithDryRun
+	trial          bool              // Admitted as a trial by a half open circuit
+	id             uint64            // See Error.CallID
+	name           string            // Name of the command after WithNameValidator
+	bypass         bool              // See WithBypass
+	queue          time.Duration     // Submission to admission, see Error.QueueDuration
+	service        time.Duration     // Admission to result, see Error.ServiceDuration
+	idempotencyKey string            // See WithIdempotencyKey
+	slot           bool              // Goroutine slot reserved for the command, see WithMaxGoroutines
+}
+
+func newCall(opts []CallOption) *call {
+	c := &call{weight: 1}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.weight < 1 {
+		c.weight = 1
+	}
+	return c
+}
+
+// holdsTokens reports whether the call holds tokens of b
+func (c *call) holdsTokens(b *Breaker) bool {
+	for _, u := range c.untokened {
+		if u == b {
+			return false
+		}
+	}
+	return true
+}
+
+// WithWeight makes a heavy call consume n tokens instead of one, all of them are released when the
+// call completes. A call heavier than the concurrency of the breaker is always rejected
+func WithWeight(n int) CallOption {
+	return func(c *call) { c.weight = n }
+}
+
+// WithBypass runs the call whatever the state of the circuit, for health probes and admin operations
+// that must reach the downstream while it is open. The call still needs capacity and is still bound
+// by its timeout. Its outcome is left out of the trip policy, a bypass call never trips or repairs
+// the circuit. A shut down breaker still rejects it
+func WithBypass() CallOption {
+	return func(c *call) { c.bypass = true }
+}
+
+// WithCallTimeout overrides the timeout of a single call, taking precedence over the Timeout
+// interface of the command and the timeout of the breaker
+func WithCallTimeout(d time.Duration) CallOption {
+	return func(c *call) { c.timeout = d }
+}
+
+// WithLabels attaches labels such as region or tenant to a call, they are copied to its Result and
+// added to every log entry of the call. Labels end up as metric dimensions, keep their values to a
+// small bounded set, never use ids such as user or request ids
+func WithLabels(labels map[string]string) CallOption {
+	return func(c *call) { c.labels = labels }
+}
+
+// fields adds the labels of the call to log fields
+func (c *call) fields(f logrus.Fields) logrus.Fields {
+	if len(c.labels) == 0 {
+		return f
+	}
+	merged := logrus.Fields{}
+	for k, v := range c.labels {
+		merged[k] = v
+	}
+	for k, v := range f {
+		merged[k] = v
+	}
+	return merged
+}
+
+// WithLogLevels overrides the level each event is logged at. High frequency events such as
+// rejections and timeouts default to Debug, transitions to Warn
+func WithLogLevels(levels map[EventType]logrus.Level) Option {
+	return func(b *Breaker) { b.logLevels = levels }
+}
+
+// WithLogSampling logs only 1 in n of the repetitive events, rejections, timeouts, cancellations,
+// successes and failures, so a sustained outage does not flood the logs. Transitions, recoveries,
+// panics and internal errors are always logged. An EventRecorder still sees every event
+func WithLogSampling(n int) Option {
+	return func(b *Breaker) { b.logSampling = n }
+}
+
+// WithSerializedCallbacks runs the DefaultFunc and CleanupFunc of all calls one at a time, in the
+// order the calls were admitted, for callbacks touching shared state. A rejected call takes its turn
+// when rejected, its error is returned at once and its callbacks run on a goroutine once their turn
+// comes. A call with callbacks to run waits for every call admitted earlier to complete, so a slow
+// command delays the fallback of the calls admitted after it by up to its timeout. Not applied to
+// DefaultFunc with WithSpeculativeFallback or WithHedge
+func WithSerializedCallbacks() Option {
+	return func(b *Breaker) { b.serial = newSequencer() }
+}
+
+// WithSpeculativeFallback starts DefaultFunc alongside CommandFunc so the fallback is ready as soon
+// as the command times out, rather than starting only then. DefaultFunc then runs for every admitted
+// call, also the successful ones, so it must only produce a result that is used on failure.
+// This costs an extra goroutine and the fallback work on every call
+func WithSpeculativeFallback() Option {
+	return func(b *Breaker) { b.speculative = true }
+}
+
+// WithNameValidator normalizes command names before they reach OnComplete, InFlightCalls and metrics,
+// for instance truncating them or folding ids out of them to bound the cardinality of labels. An empty
+// result rejects the call as invalid. The validator runs for every call, it must be cheap. Defaults to
+// passing names through
+func WithNameValidator(validate func(name string) string) Option {
+	return func(b *Breaker) { b.nameValidator = validate }
+}
+
+// commandName returns the name of commands after WithNameValidator
+func (b *Breaker) commandName(commands CommandFuncs) string {
+	if b.nameValidator == nil {
+		return commands.Name()
+	}
+	return b.nameValidator(commands.Name())
+}
+
+// WithHedge starts DefaultFunc as a hedge when CommandFunc has not completed after delay, and the call
+// completes with whichever finishes first, cutting tail latency. When the fallback wins the call is
+// OutcomeHedged, the context of a ContextCommand is canceled and CleanupFunc is called, the trip
+// policy ignores the call. When the command wins the call succeeds even though DefaultFunc ran, and
+// DefaultFunc cannot be canceled, so it must only produce a result that is used on failure. A delay not
+// shorter than the timeout never hedges
+func WithHedge(delay time.Duration) Option {
+	return func(b *Breaker) { b.hedge = delay }
+}
+
+// WithTimeoutReleasesResources registers f to be called with a command that timed out. The token of
+// the call is released on timeout but the command keeps running in its goroutine, f lets the client
+// forcibly release what the command holds, such as closing its connection, so it returns early
+func WithTimeoutReleasesResources(f func(commands CommandFuncs)) Option {
+	return func(b *Breaker) { b.abandon = f }
+}
+
+// WithTTL shuts the breaker down d after it is created, for breakers tied to a request or a job that
+// may never be closed. Calling Close before d stops the healthcheck and so cancels the TTL
+func WithTTL(d time.Duration) Option {
+	return func(b *Breaker) { b.ttl = d }
+}
+
+// WithCleanupOnRejection decides whether CleanupFunc is called for a call that was never run, because
+// it was rejected or its context was already done. DefaultFunc is always called. Defaults to true,
+// CleanupFunc is then called after DefaultFunc on every path but success. With false, CleanupFunc is
+// only called when the command actually ran and timed out, panicked or had its context done
+func WithCleanupOnRejection(cleanup bool) Option {
+	return func(b *Breaker) { b.noCleanupOnRejection = !cleanup }
+}
+
+// WithPanicPropagation crashes the process when a command panics, after DefaultFunc and CleanupFunc
+// were called, instead of reporting the panic as an Error. Meant to surface bugs early in development,
+// defaults to false
+func WithPanicPropagation(propagate bool) Option {
+	return func(b *Breaker) { b.propagatePanics = propagate }
+}
+
+// WithMaxQueueItems caps the calls waiting for admission, each holding a goroutine and its command,
+// so sustained overload of a blocking Limiter cannot exhaust memory. Calls beyond the cap are
+// rejected. Defaults to 0, no cap
+func WithMaxQueueItems(n int) Option {
+	return func(b *Breaker) { b.maxQueued = int64(n) }
+}
+
+// WithDryRun makes the breaker observe without protecting, to gauge how it would behave under real
+// traffic before enabling it. The circuit trips, recovers and fires its callbacks as usual, but calls
+// an open circuit or exhausted capacity would reject are admitted anyway and only logged and counted,
+// see Stats.WouldTrip and Stats.WouldReject. Defaults to false
+func WithDryRun(dryRun bool) Option {
+	return func(b *Breaker) { b.dryRun = dryRun }
+}
@@ -0,0 +1,50 @@
+// Package otel exports breaker metrics to OpenTelemetry. It is a module of its own so the breaker
+// module does not depend on OpenTelemetry
+package otel
+
+import (
+	"context"
+
+	"github.com/rvauradkar1/breaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+)
+
+// Register registers instruments observing b with meter, read from the statistics of b on every
+// collection. Unregister the returned Registration when b is discarded
+func Register(meter metric.Meter, b *breaker.Breaker) (metric.Registration, error) {
+	calls, err := meter.Int64ObservableCounter("breaker.calls", instrument.WithDescription("Completed calls by outcome"))
+	if err != nil {
+		return nil, err
+	}
+	timeouts, err := meter.Int64ObservableCounter("breaker.timeouts", instrument.WithDescription("Calls that timed out"))
+	if err != nil {
+		return nil, err
+	}
+	rejections, err := meter.Int64ObservableCounter("breaker.rejections", instrument.WithDescription("Calls that were not admitted"))
+	if err != nil {
+		return nil, err
+	}
+	inFlight, err := meter.Int64ObservableUpDownCounter("breaker.in_flight", instrument.WithDescription("Calls holding a token"))
+	if err != nil {
+		return nil, err
+	}
+	state, err := meter.Int64ObservableGauge("breaker.state", instrument.WithDescription("0 closed, 1 open, 2 half open, 3 shutdown"))
+	if err != nil {
+		return nil, err
+	}
+	name := attribute.String("name", b.Config().Name)
+	return meter.RegisterCallback(func(ctx context.Context, o metric.Observer) error {
+		s := b.Stats()
+		for _, outcome := range []breaker.Outcome{breaker.OutcomeSuccess, breaker.OutcomeTimeout, breaker.OutcomeRejected,
+			breaker.OutcomeIgnored, breaker.OutcomePanic, breaker.OutcomeFailure, breaker.OutcomeHedged} {
+			o.ObserveInt64(calls, int64(s.Count(outcome)), name, attribute.String("outcome", outcome.String()))
+		}
+		o.ObserveInt64(timeouts, int64(s.Count(breaker.OutcomeTimeout)), name)
+		o.ObserveInt64(rejections, int64(s.Count(breaker.OutcomeRejected)), name)
+		o.ObserveInt64(inFlight, int64(b.Snapshot().InFlight), name)
+		o.ObserveInt64(state, int64(b.State()), name)
+		return nil
+	}, calls, timeouts, rejections, inFlight, state)
+}
@@ -0,0 +1,69 @@
+package otel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rvauradkar1/breaker"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// quick completes at once
+type quick struct{}
+
+func (q *quick) CommandFunc() {}
+func (q *quick) DefaultFunc() {}
+func (q *quick) CleanupFunc() {}
+func (q *quick) Name() string { return "quick" }
+
+// sum returns the total of the int64 data points of the metric called name
+func sum(rm metricdata.ResourceMetrics, name string) (int64, bool) {
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			var total int64
+			switch d := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, p := range d.DataPoints {
+					total += p.Value
+				}
+			case metricdata.Gauge[int64]:
+				for _, p := range d.DataPoints {
+					total += p.Value
+				}
+			}
+			return total, true
+		}
+	}
+	return 0, false
+}
+
+func Test_register(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	b := breaker.New("name", time.Second, 1)
+	defer b.Shutdown()
+	reg, err := Register(provider.Meter("test"), b)
+	if err != nil {
+		t.Fatalf("Was expecting instruments to register, instead got %v", err)
+	}
+	defer reg.Unregister()
+	<-b.Execute(&quick{})
+	<-b.Execute(&quick{})
+	b.Shutdown()
+	<-b.Execute(&quick{})
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect failed %v", err)
+	}
+	want := map[string]int64{"breaker.calls": 3, "breaker.rejections": 1, "breaker.timeouts": 0, "breaker.state": int64(breaker.StateShutdown)}
+	for name, v := range want {
+		if got, ok := sum(rm, name); !ok || got != v {
+			t.Errorf("Was expecting %s to be %d, instead got %d %v", name, v, got, ok)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package breaker
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_parent_trip_rejects_children(t *testing.T) {
+	parent := New("service", time.Second, 10)
+	parent.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer parent.Shutdown()
+	child1 := New("endpoint1", time.Second, 10).WithParent(parent)
+	child1.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer child1.Shutdown()
+	child2 := New("endpoint2", time.Second, 10).WithParent(parent)
+	child2.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer child2.Shutdown()
+
+	if err := <-child1.Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Was expecting success, instead got %v", err)
+	}
+	parent.trip("test")
+	for _, child := range []*Breaker{child1, child2} {
+		if err := <-child.Execute(&wrapper3{}); err.Success() {
+			t.Errorf("Child %s should have been rejected by open parent", child.name)
+		}
+		if child.State() != StateClosed {
+			t.Errorf("Child %s should not have tripped", child.name)
+		}
+	}
+	if !waitFor(func() bool { return parent.limiter.InFlight() == 0 }) {
+		t.Errorf("Parent tokens should have been released, in flight %d", parent.limiter.InFlight())
+	}
+}
+
+func Test_child_trip_does_not_affect_siblings(t *testing.T) {
+	parent := New("service", time.Second, 10)
+	parent.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer parent.Shutdown()
+	child1 := New("endpoint1", time.Second, 0).WithParent(parent)
+	child1.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer child1.Shutdown()
+	child2 := New("endpoint2", time.Second, 10).WithParent(parent)
+	child2.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer child2.Shutdown()
+
+	if err := <-child1.Execute(&wrapper3{}); err.Success() {
+		t.Errorf("Saturated child should have rejected")
+	}
+	if child1.State() != StateOpen {
+		t.Errorf("Saturated child should have tripped")
+	}
+	if err := <-child2.Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Sibling should not be affected, instead got %v", err)
+	}
+	if parent.State() != StateClosed {
+		t.Errorf("Parent should not have tripped")
+	}
+	if !waitFor(func() bool { return parent.limiter.InFlight() == 0 }) {
+		t.Errorf("Parent tokens should have been released, in flight %d", parent.limiter.InFlight())
+	}
+}
+
+func Test_keyed_trip(t *testing.T) {
+	b := NewWithOptions("hosts", WithTimeout(10*time.Millisecond), WithConcurrency(10), WithFailureThreshold(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	<-b.ExecuteKeyed("A", w)
+	<-b.ExecuteKeyed("A", w)
+	if err := <-b.ExecuteKeyed("B", &wrapper3{}); !err.Success() {
+		t.Errorf("Was expecting success on B, instead got %v", err)
+	}
+	if got := b.KeyState("A"); got != StateOpen {
+		t.Errorf("Was expecting A to trip, instead got %v", got)
+	}
+	if err := <-b.ExecuteKeyed("A", &wrapper3{}); err.Reason() != ReasonOpen {
+		t.Errorf("Was expecting A to reject, instead got %v", err)
+	}
+	if b.KeyState("B") != StateClosed || b.KeyState("C") != StateClosed || b.State() != StateClosed {
+		t.Errorf("Was expecting B, unused C and the breaker closed, instead got %v %v %v", b.KeyState("B"), b.KeyState("C"), b.State())
+	}
+	if !waitFor(func() bool { return b.limiter.InFlight() == 0 }) {
+		t.Errorf("Keyed calls should have released the tokens of the breaker, in flight %d", b.limiter.InFlight())
+	}
+	b.Shutdown()
+	if got := b.KeyState("B"); got != StateShutdown {
+		t.Errorf("Was expecting keys to shut down with the breaker, instead got %v", got)
+	}
+}
+
+func Test_key_inherits_options(t *testing.T) {
+	clock := newFakeClock()
+	var probes int32
+	b := NewWithOptions("hosts", WithTimeout(time.Second), WithConcurrency(10), WithNoLogging(), withClock(clock),
+		WithHalfOpenTimeout(time.Millisecond), WithEvents(10), WithTTL(time.Hour), WithSerializedCallbacks(),
+		WithStartupProbe(func() error { atomic.AddInt32(&probes, 1); return nil }))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if err := <-b.ExecuteKeyed("A", &wrapper3{}); !err.Success() {
+		t.Errorf("Was expecting success on A, instead got %v", err)
+	}
+	k := b.key("A", false)
+	if !k.noLogging || k.clock != clock || k.halfOpenTimeout != time.Millisecond {
+		t.Errorf("Was expecting the key to inherit the options of the breaker, instead got %v %v %v", k.noLogging, k.clock, k.halfOpenTimeout)
+	}
+	if k.events != b.events || k.serial != b.serial || k.ttl != 0 || k.limiter == b.limiter {
+		t.Errorf("Was expecting the key to share events and callback turns, without TTL and limiter of its own")
+	}
+	<-b.ExecuteKeyed("B", &wrapper3{})
+	if n := atomic.LoadInt32(&probes); n != 1 {
+		t.Errorf("Was expecting the startup probe to run once for the breaker, instead got %d runs", n)
+	}
+	if len(b.Dependencies()) != 0 {
+		t.Errorf("Was expecting keys out of the dependency graph, instead got %v", b.Dependencies())
+	}
+}
+
+func Test_key_caps_stay_with_breaker(t *testing.T) {
+	b := NewWithOptions("hosts", WithTimeout(time.Second), WithConcurrency(10), WithMaxGoroutines(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	first := b.ExecuteKeyed("A", w)
+	if !waitFor(func() bool { return b.Stats().Goroutines == 2 }) {
+		t.Fatalf("Was expecting the keyed call to hold the goroutines of the breaker, instead got %d", b.Stats().Goroutines)
+	}
+	if err := <-b.ExecuteKeyed("B", &wrapper3{}); err.Reason() != ReasonSaturated {
+		t.Errorf("Was expecting the goroutine cap of the breaker to reject key B, instead got %v", err)
+	}
+	close(w.release)
+	<-first
+
+	l := &blockingLimiter{semaphore: make(chan bool, 1)}
+	q := NewWithOptions("hosts", WithTimeout(time.Second), WithLimiter(l), WithMaxQueueItems(1))
+	q.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer q.Shutdown()
+	w = &blocker{release: make(chan bool)}
+	first = q.ExecuteKeyed("A", w)
+	if !waitFor(func() bool { return l.InFlight() == 1 }) {
+		t.Fatalf("Was expecting the first keyed call to run")
+	}
+	second := q.ExecuteKeyed("B", &wrapper3{})
+	if !waitFor(func() bool { return q.Queued() == 1 }) {
+		t.Fatalf("Was expecting key B to queue on the breaker, instead got %d queued", q.Queued())
+	}
+	if err := <-q.ExecuteKeyed("C", &wrapper3{}); err.Reason() != ReasonSaturated {
+		t.Errorf("Was expecting the queue cap of the breaker to reject key C, instead got %v", err)
+	}
+	close(w.release)
+	<-first
+	<-second
+}
+
+func Test_dependency_graph(t *testing.T) {
+	service := NewWithOptions("service", WithTimeout(time.Second), WithConcurrency(10), WithOpenOnDependencies())
+	service.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer service.Shutdown()
+	db := New("db", time.Second, 10).WithParent(service)
+	db.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer db.Shutdown()
+	cache := New("cache", time.Second, 10)
+	cache.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer cache.Shutdown()
+	service.DependsOn(cache, db)
+
+	deps := service.Dependencies()
+	if len(deps) != 2 || deps[0] != db || deps[1] != cache {
+		t.Errorf("Was expecting db and cache as dependencies, instead got %v", deps)
+	}
+	if d := cache.Dependents(); len(d) != 1 || d[0] != service {
+		t.Errorf("Was expecting service as dependent of cache, instead got %v", d)
+	}
+	db.trip("test")
+	if service.State() != StateClosed {
+		t.Errorf("One open dependency should not trip the service")
+	}
+	cache.trip("test")
+	if service.State() != StateOpen {
+		t.Errorf("Was expecting the service to open with all its dependencies, instead got %v", service.State())
+	}
+}
@@ -0,0 +1,61 @@
+package breaker
+
+// DefaultFailurePredicate counts every unsuccessful call as a failure
+func DefaultFailurePredicate(e Error) bool {
+	return !e.Success()
+}
+
+// TripOn selects the failed outcomes that feed the trip policy, see WithTripOn
+type TripOn int
+
+const (
+	TripOnTimeouts   TripOn = 1 << iota // Timed out calls and calls whose context was done
+	TripOnErrors                        // Calls that panicked or returned an error
+	TripOnRejections                    // Calls that were not admitted
+	TripOnAll        = TripOnTimeouts | TripOnErrors | TripOnRejections
+)
+
+// tripsOn reports whether outcome feeds the trip policy
+func (b *Breaker) tripsOn(outcome Outcome) bool {
+	on := b.tripOn
+	if on == 0 {
+		on = TripOnAll
+	}
+	switch outcome {
+	case OutcomeTimeout:
+		return on&TripOnTimeouts != 0
+	case OutcomePanic, OutcomeFailure:
+		return on&TripOnErrors != 0
+	case OutcomeRejected:
+		return on&TripOnRejections != 0
+	case OutcomeHedged:
+		// Slower than the hedge delay is not a failure
+		return false
+	}
+	return true
+}
+
+// record feeds the outcome of a call to the trip policy. Ignored calls do not count either way.
+// While half open any call that ran decides whether the circuit closes or reopens. Returns whether
+// the call counted as a failure and whether it changed the state of the circuit
+func (b *Breaker) record(outcome Outcome, be Error) (failed, changed bool) {
+	if outcome == OutcomeIgnored {
+		b.apply(event{kind: eventIgnored})
+		return false, false
+	}
+	if !b.tripsOn(outcome) {
+		if outcome != OutcomeRejected {
+			// Call ran, a trial in flight is over
+			b.apply(event{kind: eventIgnored})
+		}
+		return false, false
+	}
+	failed = DefaultFailurePredicate(be)
+	if b.failurePredicate != nil {
+		failed = b.failurePredicate(be)
+	}
+	if outcome == OutcomeRejected {
+		return false, b.apply(event{kind: eventRejection, failed: failed})
+	}
+	return failed, b.apply(event{kind: eventCall, failed: failed})
+}
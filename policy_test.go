@@ -0,0 +1,143 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_failure_threshold_trips(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(2), WithFailureThreshold(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	<-b.Execute(w)
+	if b.State() != StateClosed {
+		t.Errorf("One failure should not trip the circuit")
+	}
+	<-b.Execute(w)
+	if b.State() != StateOpen {
+		t.Errorf("Two consecutive failures should trip the circuit")
+	}
+}
+
+func Test_failure_predicate(t *testing.T) {
+	onlyPanics := func(e Error) bool { return e.Panic() }
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(2), WithFailureThreshold(2), WithFailurePredicate(onlyPanics))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	<-b.Execute(w)
+	<-b.Execute(w)
+	if b.State() != StateClosed {
+		t.Errorf("Timeouts should not count as failures")
+	}
+	<-b.Execute(&panicker{})
+	<-b.Execute(&panicker{})
+	if b.State() != StateOpen {
+		t.Errorf("Panics should count as failures")
+	}
+}
+
+func Test_trip_on(t *testing.T) {
+	for _, on := range []TripOn{TripOnAll, TripOnTimeouts | TripOnErrors} {
+		b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithFailureThreshold(3), WithTripOn(on))
+		b.SetHealthCheckInterval(100000 * time.Millisecond)
+		<-b.Execute(&panicker{})
+		<-b.Execute(&panicker{})
+		for i := 0; i < 10; i++ {
+			// Too heavy to ever be admitted, rejected without saturating
+			<-b.Execute(&wrapper3{}, WithWeight(5))
+		}
+		want := StateOpen
+		if on&TripOnRejections == 0 {
+			want = StateClosed
+		}
+		if b.State() != want {
+			t.Errorf("Trip on %v: Was expecting %v after rejections, instead got %v", on, want, b.State())
+		}
+		<-b.Execute(&panicker{})
+		if b.State() != StateOpen {
+			t.Errorf("Trip on %v: Third failure should trip, instead got %v", on, b.State())
+		}
+		b.Shutdown()
+	}
+}
+
+func Test_dry_run(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(2), WithFailureThreshold(2), WithDryRun(true))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	<-b.Execute(w)
+	<-b.Execute(w)
+	if b.State() != StateOpen {
+		t.Errorf("Was expecting the circuit to trip, instead got %v", b.State())
+	}
+	for i := 0; i < 3; i++ {
+		var ran int32
+		if e := <-b.Execute(&running{counter: &counter{}, ran: &ran}); !e.Success() || ran != 1 {
+			t.Errorf("Was expecting the command to run in dry run, instead got %v", e)
+		}
+	}
+	s := b.Stats()
+	if s.WouldTrip != 1 || s.WouldReject != 3 {
+		t.Errorf("Was expecting 1 trip and 3 rejections, instead got %d and %d", s.WouldTrip, s.WouldReject)
+	}
+}
+
+func Test_consecutive_counters(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(2), WithFailureThreshold(3), WithSuccessThreshold(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	for i := 1; i <= 2; i++ {
+		<-b.Execute(w)
+		if got := b.ConsecutiveFailures(); got != i {
+			t.Errorf("Was expecting %d failures, instead got %d", i, got)
+		}
+	}
+	if s := b.Snapshot(); s.Failures != 2 {
+		t.Errorf("Was expecting the snapshot to show 2 failures, instead got %d", s.Failures)
+	}
+	<-b.Execute(&counter{})
+	if got := b.ConsecutiveFailures(); got != 0 {
+		t.Errorf("Was expecting a success to reset failures, instead got %d", got)
+	}
+	b.trip("test")
+	b.triggerHealthCheck()
+	<-b.Execute(&counter{})
+	if got := b.ConsecutiveSuccesses(); got != 1 || b.State() != StateHalfOpen {
+		t.Errorf("Was expecting 1 successful trial while half open, instead got %d and %v", got, b.State())
+	}
+}
+
+func Test_amend(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(2), WithFailureThreshold(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	first := <-b.Execute(w)
+	if err := b.Amend(first.CallID(), OutcomeSuccess); err != nil {
+		t.Errorf("Was expecting the failure to be amended, instead got %v", err)
+	}
+	s := b.Stats()
+	if b.ConsecutiveFailures() != 0 || s.Count(OutcomeTimeout) != 0 || s.Count(OutcomeSuccess) != 1 {
+		t.Errorf("Was expecting the amended call to count as a success, instead got %d failures and %v", b.ConsecutiveFailures(), s.LatencyPercentiles(50))
+	}
+	<-b.Execute(w)
+	second := <-b.Execute(w)
+	if b.State() != StateOpen {
+		t.Fatalf("Was expecting two failures to trip the circuit, instead got %v", b.State())
+	}
+	if err := b.Amend(second.CallID(), OutcomeIgnored); err != nil || b.State() != StateClosed {
+		t.Errorf("Was expecting amending the tripping call to close the circuit, instead got %v and %v", err, b.State())
+	}
+	if err := b.Amend(12345, OutcomeSuccess); err != ErrNotAmendable {
+		t.Errorf("Was expecting ErrNotAmendable, instead got %v", err)
+	}
+}
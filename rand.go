@@ -0,0 +1,34 @@
+package breaker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// WithRand sets the source of all randomness of the breaker, such as jitter, so tests can be
+// reproducible. The breaker serializes its use of r. By default every breaker has its own source,
+// the contended global source of math/rand is never used
+func WithRand(r *rand.Rand) Option {
+	return func(b *Breaker) { b.rand = r }
+}
+
+// WithHealthCheckJitter spreads each healthcheck interval uniformly by +/- fraction, so many breakers
+// created together do not probe their dependency at the same moment
+func WithHealthCheckJitter(fraction float64) Option {
+	return func(b *Breaker) { b.jitter = fraction }
+}
+
+// float64 returns a number in [0.0,1.0) from the source of the breaker
+func (b *Breaker) float64() float64 {
+	b.randMu.Lock()
+	defer b.randMu.Unlock()
+	return b.rand.Float64()
+}
+
+// jittered spreads d by the configured healthcheck jitter
+func (b *Breaker) jittered(d time.Duration) time.Duration {
+	if b.jitter <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + b.jitter*(2*b.float64()-1)))
+}
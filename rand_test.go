@@ -0,0 +1,39 @@
+package breaker
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func Test_deterministic_jitter(t *testing.T) {
+	sequence := func() []time.Duration {
+		b := NewWithOptions("name", WithTimeout(time.Second), WithRand(rand.New(rand.NewSource(42))), WithHealthCheckJitter(0.5))
+		b.SetHealthCheckInterval(100000 * time.Millisecond)
+		defer b.Shutdown()
+		var got []time.Duration
+		for i := 0; i < 10; i++ {
+			got = append(got, b.jittered(100*time.Millisecond))
+		}
+		return got
+	}
+	first, second := sequence(), sequence()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("Was expecting reproducible jitter, instead got %v and %v", first, second)
+			break
+		}
+		if first[i] < 50*time.Millisecond || first[i] > 150*time.Millisecond {
+			t.Errorf("Was expecting jitter within 50%%, instead got %v", first[i])
+		}
+	}
+}
+
+func Test_no_jitter_by_default(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if got := b.jittered(100 * time.Millisecond); got != 100*time.Millisecond {
+		t.Errorf("Was expecting no jitter, instead got %v", got)
+	}
+}
@@ -0,0 +1,53 @@
+package breaker
+
+import "github.com/pkg/errors"
+
+// Reason tells in a machine readable way why a call did not succeed
+type Reason int
+
+const (
+	ReasonNone      Reason = iota // Call succeeded
+	ReasonOpen                    // Circuit was open or half open with a trial in progress
+	ReasonSaturated               // No capacity left, tokens or goroutines
+	ReasonTimeout                 // Command timed out
+	ReasonShutdown                // Breaker was shut down
+	ReasonPanic                   // Command panicked
+	ReasonCanceled                // Context of the call was done
+	ReasonInvalid                 // Call can never run, such as a nil command or a breaker not created with New
+	ReasonInternal                // Breaker itself failed
+	ReasonFailed                  // Command returned an error
+	ReasonHedged                  // Fallback completed before the command, see WithHedge
+	ReasonDenied                  // Admission func refused the call, see WithAdmissionFunc
+)
+
+var reasonNames = [...]string{"none", "open", "saturated", "timeout", "shutdown", "panic", "canceled", "invalid", "internal", "failed", "hedged", "denied"}
+
+func (r Reason) String() string {
+	if r < 0 || int(r) >= len(reasonNames) {
+		return "unknown"
+	}
+	return reasonNames[r]
+}
+
+// rejection is an admission error carrying its reason
+type rejection struct {
+	reason Reason
+	err    error
+}
+
+func (r rejection) Error() string { return r.err.Error() }
+func (r rejection) Unwrap() error { return r.err }
+
+// reject wraps err with the reason of the rejection
+func reject(reason Reason, err error) error {
+	return rejection{reason: reason, err: err}
+}
+
+// reasonOf returns the reason of an admission error
+func reasonOf(err error) Reason {
+	var r rejection
+	if errors.As(err, &r) {
+		return r.reason
+	}
+	return ReasonInternal
+}
@@ -0,0 +1,74 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_reasons(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	newBreaker := func() *Breaker {
+		b := New("name", 20*time.Millisecond, 1)
+		b.SetHealthCheckInterval(100000 * time.Millisecond)
+		return b
+	}
+	tests := []struct {
+		name  string
+		setup func(b *Breaker)
+		ctx   context.Context
+		cmd   CommandFuncs
+		want  Reason
+	}{
+		{"success", func(b *Breaker) {}, context.Background(), &wrapper3{}, ReasonNone},
+		{"timeout", func(b *Breaker) {}, context.Background(), w, ReasonTimeout},
+		{"panic", func(b *Breaker) {}, context.Background(), &panicker{}, ReasonPanic},
+		{"canceled", func(b *Breaker) {}, canceled, &wrapper3{}, ReasonCanceled},
+		{"open", func(b *Breaker) { b.trip("test") }, context.Background(), &wrapper3{}, ReasonOpen},
+		{"saturated", func(b *Breaker) { b.limiter.Acquire(context.Background()) }, context.Background(), &wrapper3{}, ReasonSaturated},
+		{"shutdown", func(b *Breaker) { b.Shutdown() }, context.Background(), &wrapper3{}, ReasonShutdown},
+		{"invalid", func(b *Breaker) {}, context.Background(), nil, ReasonInvalid},
+	}
+	for _, tt := range tests {
+		b := newBreaker()
+		tt.setup(b)
+		if err := <-b.ExecuteContext(tt.ctx, tt.cmd); err.Reason() != tt.want {
+			t.Errorf("%s: Was expecting reason %v, instead got %v", tt.name, tt.want, err.Reason())
+		}
+		b.Shutdown()
+	}
+}
+
+func Test_retry_hints(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	tests := []struct {
+		name  string
+		setup func()
+		cmd   CommandFuncs
+		retry bool
+		after time.Duration
+	}{
+		{"timeout", func() {}, w, true, 0},
+		{"panic", func() { waitFor(func() bool { return b.limiter.InFlight() == 0 }) }, &panicker{}, false, 0},
+		{"saturated", func() {
+			waitFor(func() bool { return b.limiter.InFlight() == 0 })
+			b.limiter.Acquire(context.Background())
+		}, &wrapper3{}, true, 0},
+		{"open", func() { b.limiter.Release() }, &wrapper3{}, false, 100 * time.Second},
+		{"shutdown", func() { b.Shutdown() }, &wrapper3{}, false, 0},
+	}
+	for _, tt := range tests {
+		tt.setup()
+		err := <-b.Execute(tt.cmd)
+		if err.ShouldRetry() != tt.retry || err.RetryAfter() != tt.after {
+			t.Errorf("%s: Was expecting retry %v after %v, instead got %v after %v", tt.name, tt.retry, tt.after, err.ShouldRetry(), err.RetryAfter())
+		}
+	}
+}
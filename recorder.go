@@ -0,0 +1,91 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event is an event of a breaker as captured by an EventRecorder
+type Event struct {
+	Time    time.Time
+	Type    EventType
+	Message string
+	Fields  logrus.Fields // Same as logged, such as from and to of a transition
+}
+
+// EventRecorder captures in order every event of a breaker, whatever its log level. Meant for tests
+// asserting on sequences of events without parsing logs. The zero value is ready to use
+type EventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// WithEventRecorder hands every event of the breaker to r
+func WithEventRecorder(r *EventRecorder) Option {
+	return func(b *Breaker) { b.recorder = r }
+}
+
+func (r *EventRecorder) add(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// Drain returns the events captured so far and forgets them
+func (r *EventRecorder) Drain() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+// WithEvents streams every event of the breaker on the channel returned by Events, buffered with
+// room for buffer events. Events are dropped rather than slowing the breaker down when the consumer
+// falls behind. The channel is closed on Shutdown, after the transition to shutdown
+func WithEvents(buffer int) Option {
+	return func(b *Breaker) { b.events = &eventStream{ch: make(chan Event, buffer)} }
+}
+
+// Events returns the channel of WithEvents, nil without it
+func (b *Breaker) Events() <-chan Event {
+	if b.events == nil {
+		return nil
+	}
+	return b.events.ch
+}
+
+// eventStream is the channel of WithEvents, closed guards against sending on it once closed
+type eventStream struct {
+	mu     sync.Mutex
+	ch     chan Event
+	closed bool
+}
+
+// send hands e to the consumer, closing the channel after it if last
+func (s *eventStream) send(e Event, last bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+	}
+	if last {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+func (s *eventStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
@@ -0,0 +1,138 @@
+package breaker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Registry keeps track of the breakers of a process, safe for concurrent use
+type Registry struct {
+	mu        sync.RWMutex
+	breakers  map[string]*Breaker
+	aggregate func(snapshots []Snapshot) State // See SetAggregation
+}
+
+// NewRegistry initializes an empty registry
+func NewRegistry() *Registry {
+	return &Registry{breakers: make(map[string]*Breaker)}
+}
+
+// Register adds b to the registry, replacing any breaker registered under the same name
+func (r *Registry) Register(b *Breaker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakers[b.name] = b
+}
+
+// Unregister removes the breaker registered under name
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.breakers, name)
+}
+
+// Get returns the breaker registered under name, nil if there is none
+func (r *Registry) Get(name string) *Breaker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.breakers[name]
+}
+
+// Snapshot is a point in time view of the health of a breaker
+type Snapshot struct {
+	Name       string `json:"name"`
+	State      State  `json:"state"`
+	InFlight   int    `json:"inFlight"`
+	Running    int    `json:"running"`
+	Queued     int    `json:"queued"`
+	Goroutines int64  `json:"goroutines"`
+	Failures   int    `json:"consecutiveFailures"`
+	Successes  int    `json:"consecutiveSuccesses"`
+}
+
+// Snapshot returns the health of the breaker
+func (b *Breaker) Snapshot() Snapshot {
+	return Snapshot{
+		Name:       b.name,
+		State:      b.State(),
+		InFlight:   b.limiter.InFlight(),
+		Running:    b.Running(),
+		Queued:     b.Queued(),
+		Goroutines: b.Stats().Goroutines,
+		Failures:   b.ConsecutiveFailures(),
+		Successes:  b.ConsecutiveSuccesses(),
+	}
+}
+
+// Snapshot returns the health of every registered breaker, sorted by name
+func (r *Registry) Snapshot() []Snapshot {
+	r.mu.RLock()
+	breakers := make([]*Breaker, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		breakers = append(breakers, b)
+	}
+	r.mu.RUnlock()
+	snapshots := make([]Snapshot, 0, len(breakers))
+	for _, b := range breakers {
+		snapshots = append(snapshots, b.Snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+	return snapshots
+}
+
+// WorstState is the default aggregation of AggregateState: shutdown if any breaker is shut down,
+// else open if any is open, else half open if any is half open, else closed
+func WorstState(snapshots []Snapshot) State {
+	worst := StateClosed
+	for _, s := range snapshots {
+		if severity(s.State) > severity(worst) {
+			worst = s.State
+		}
+	}
+	return worst
+}
+
+// severity ranks states from healthy to unusable
+func severity(s State) int {
+	switch s {
+	case StateClosed:
+		return 0
+	case StateHalfOpen:
+		return 1
+	case StateOpen:
+		return 2
+	}
+	return 3
+}
+
+// SetAggregation replaces WorstState as the rule AggregateState applies, for instance to tolerate
+// optional dependencies being open
+func (r *Registry) SetAggregation(f func(snapshots []Snapshot) State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.aggregate = f
+}
+
+// AggregateState sums up the registered breakers in a single state, for a readiness check of the
+// process. An empty registry is closed
+func (r *Registry) AggregateState() State {
+	r.mu.RLock()
+	f := r.aggregate
+	r.mu.RUnlock()
+	if f == nil {
+		f = WorstState
+	}
+	return f(r.Snapshot())
+}
+
+// Handler serves the snapshot of every registered breaker as JSON, for example on /debug/breakers
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.Snapshot()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
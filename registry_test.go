@@ -0,0 +1,103 @@
+package breaker
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func Test_registry_snapshot(t *testing.T) {
+	r := NewRegistry()
+	b1, b2, b3 := newQuiet("b1", 1), newQuiet("b2", 1), newQuiet("b3", 1)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	b3.Shutdown()
+	b2.trip("test")
+	for _, b := range []*Breaker{b3, b1, b2} {
+		r.Register(b)
+	}
+	got := r.Snapshot()
+	want := []State{StateClosed, StateOpen, StateShutdown}
+	if len(got) != 3 {
+		t.Fatalf("Was expecting 3 snapshots, instead got %v", got)
+	}
+	for i := range got {
+		if got[i].Name != "b"+strconv.Itoa(i+1) || got[i].State != want[i] {
+			t.Errorf("Unexpected snapshot %d %+v", i, got[i])
+		}
+	}
+}
+
+func Test_registry_handler(t *testing.T) {
+	r := NewRegistry()
+	b := newQuiet("b1", 1)
+	defer b.Shutdown()
+	r.Register(b)
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/breakers", nil))
+	var got []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Was expecting JSON, got %s", rec.Body.String())
+	}
+	if len(got) != 1 || got[0]["name"] != "b1" || got[0]["state"] != "closed" {
+		t.Errorf("Unexpected body %s", rec.Body.String())
+	}
+}
+
+func Test_registry_concurrent(t *testing.T) {
+	r := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			b := newQuiet("b"+strconv.Itoa(i), 1)
+			r.Register(b)
+			b.Shutdown()
+			r.Unregister(b.name)
+		}(i)
+		go func() {
+			defer wg.Done()
+			r.Snapshot()
+		}()
+	}
+	wg.Wait()
+	if len(r.Snapshot()) != 0 {
+		t.Errorf("All breakers should have been unregistered")
+	}
+}
+
+func Test_registry_aggregate_state(t *testing.T) {
+	r := NewRegistry()
+	if got := r.AggregateState(); got != StateClosed {
+		t.Errorf("Was expecting an empty registry to be closed, instead got %v", got)
+	}
+	b1, b2, b3 := newQuiet("b1", 1), newQuiet("b2", 1), newQuiet("b3", 1)
+	defer b1.Shutdown()
+	defer b2.Shutdown()
+	defer b3.Shutdown()
+	for _, b := range []*Breaker{b1, b2, b3} {
+		r.Register(b)
+	}
+	b2.trip("test")
+	if got := r.AggregateState(); got != StateOpen {
+		t.Errorf("Was expecting %v, instead got %v", StateOpen, got)
+	}
+	b3.Shutdown()
+	if got := r.AggregateState(); got != StateShutdown {
+		t.Errorf("Was expecting %v, instead got %v", StateShutdown, got)
+	}
+	r.SetAggregation(func(snapshots []Snapshot) State {
+		for _, s := range snapshots {
+			if s.Name == "b1" {
+				return s.State
+			}
+		}
+		return StateShutdown
+	})
+	if got := r.AggregateState(); got != StateClosed {
+		t.Errorf("Was expecting the custom aggregation to only consider b1, instead got %v", got)
+	}
+}
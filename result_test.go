@@ -0,0 +1,63 @@
+package breaker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_execute_result_outcomes(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		commands CommandFuncs
+		want     Outcome
+	}{
+		{"success", context.Background(), &wrapper3{}, OutcomeSuccess},
+		{"timeout", context.Background(), w, OutcomeTimeout},
+		{"panic", context.Background(), &panicker{}, OutcomePanic},
+		{"ignored", canceled, &wrapper3{}, OutcomeIgnored},
+	}
+	for _, tt := range tests {
+		r := <-b.ExecuteResult(tt.ctx, tt.commands)
+		if r.Outcome != tt.want {
+			t.Errorf("%s: was expecting %v, instead got %v", tt.name, tt.want, r.Outcome)
+		}
+		if (r.Err == nil) != (tt.want == OutcomeSuccess) {
+			t.Errorf("%s: Err should be nil only on success, got %v", tt.name, r.Err)
+		}
+		if r.Duration < 0 {
+			t.Errorf("%s: duration should not be negative, got %v", tt.name, r.Duration)
+		}
+	}
+	b.Shutdown()
+	if r := <-b.ExecuteResult(context.Background(), &wrapper3{}); r.Outcome != OutcomeRejected || r.Err == nil {
+		t.Errorf("Was expecting a rejection, instead got %+v", r)
+	}
+}
+
+func Test_error_equal(t *testing.T) {
+	b := New("name", 5*time.Millisecond, 2)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	timeout := Error{isTimeout: true, reason: ReasonTimeout}
+	if err := <-b.Execute(w); !err.Equal(timeout) {
+		t.Errorf("Was expecting %+v, instead got %+v", timeout, err)
+	}
+	first, second := <-b.Execute(&panicker{}), <-b.Execute(&panicker{})
+	if !first.Equal(second) {
+		t.Errorf("Panics should be equal, instead got %+v and %+v", first, second)
+	}
+	if first.Equal(timeout) {
+		t.Errorf("Panic should not equal timeout")
+	}
+}
@@ -0,0 +1,119 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IdempotencyStore remembers the outcome of calls completed under an idempotency key, see
+// WithIdempotencyKey. Implementations backed by a shared store guard retries across processes
+type IdempotencyStore interface {
+	// Load returns the outcome stored for key, false when key was never marked complete
+	Load(key string) (Error, bool)
+	// Complete marks key complete with the outcome of its call
+	Complete(key string, outcome Error)
+}
+
+// memoryStore keeps the outcome of each key for ttl after it completes
+type memoryStore struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	outcomes map[string]stored
+	sweep    int // Size at which expired keys are next dropped
+}
+
+// stored is a completed outcome and when it is forgotten
+type stored struct {
+	outcome Error
+	expires time.Time
+}
+
+// NewMemoryStore returns an in process IdempotencyStore remembering a key for ttl after it completes
+func NewMemoryStore(ttl time.Duration) IdempotencyStore {
+	return &memoryStore{ttl: ttl, outcomes: make(map[string]stored), sweep: 64}
+}
+
+func (m *memoryStore) Load(key string) (Error, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.outcomes[key]
+	if !ok || time.Now().After(v.expires) {
+		return Error{}, false
+	}
+	return v.outcome, true
+}
+
+func (m *memoryStore) Complete(key string, outcome Error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if len(m.outcomes) >= m.sweep {
+		// Dropping expired keys whenever the map doubles keeps the cost per key constant
+		for k, v := range m.outcomes {
+			if now.After(v.expires) {
+				delete(m.outcomes, k)
+			}
+		}
+		if m.sweep < 2*len(m.outcomes) {
+			m.sweep = 2 * len(m.outcomes)
+		}
+	}
+	m.outcomes[key] = stored{outcome: outcome, expires: now.Add(m.ttl)}
+}
+
+// WithIdempotencyStore sets where Retry keeps the outcome of completed keys, such as NewMemoryStore.
+// Without a store Retry ignores idempotency keys
+func WithIdempotencyStore(s IdempotencyStore) Option {
+	return func(b *Breaker) { b.idempotency = s }
+}
+
+// WithIdempotencyKey marks the call with a key identifying the operation, such as a request id. Retry
+// never runs a key already complete again, it returns the stored outcome. Other ways of executing
+// ignore the key
+func WithIdempotencyKey(key string) CallOption {
+	return func(c *call) { c.idempotencyKey = key }
+}
+
+// Retry executes commands up to attempts times, doubling backoff between attempts, until one succeeds
+// or fails with an Error not worth retrying, see Error.ShouldRetry. The last Error is returned. A call
+// with an idempotency key is only run while the key is not complete, a success completes the key.
+// Fewer than 1 attempts is rejected as ReasonInvalid
+func (b *Breaker) Retry(ctx context.Context, commands CommandFuncs, attempts int, backoff time.Duration, opts ...CallOption) Error {
+	if attempts < 1 {
+		return Error{reason: ReasonInvalid, Err: errors.Errorf("%d attempts, cannot run your command", attempts)}
+	}
+	key := newCall(opts).idempotencyKey
+	if b == nil || b.idempotency == nil {
+		key = ""
+	}
+	var be Error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return be
+			}
+			backoff *= 2
+		}
+		if key != "" {
+			if stored, ok := b.idempotency.Load(key); ok {
+				return stored
+			}
+		}
+		be = <-b.ExecuteContext(ctx, commands, opts...)
+		if be.Success() {
+			if key != "" {
+				b.idempotency.Complete(key, be)
+			}
+			return be
+		}
+		if !be.ShouldRetry() {
+			return be
+		}
+	}
+	return be
+}
@@ -0,0 +1,103 @@
+package breaker
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flaky sleeps past the timeout of the breaker on its first slow runs
+type flaky struct {
+	*counter
+	runs int32
+	slow int32
+}
+
+func (w *flaky) CommandFunc() {
+	if atomic.AddInt32(&w.runs, 1) <= w.slow {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func Test_retry_until_success(t *testing.T) {
+	b := New("name", 10*time.Millisecond, 5)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &flaky{counter: &counter{}, slow: 1}
+	be := b.Retry(context.Background(), w, 3, time.Millisecond)
+	if !be.Success() {
+		t.Errorf("Was expecting success, instead got %v", be)
+	}
+	if runs := atomic.LoadInt32(&w.runs); runs != 2 {
+		t.Errorf("Was expecting 2 runs, instead got %d", runs)
+	}
+}
+
+func Test_retry_completed_key(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(5), WithIdempotencyStore(NewMemoryStore(time.Minute)))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &flaky{counter: &counter{}}
+	first := b.Retry(context.Background(), w, 3, time.Millisecond, WithIdempotencyKey("order-1"))
+	second := b.Retry(context.Background(), w, 3, time.Millisecond, WithIdempotencyKey("order-1"))
+	if runs := atomic.LoadInt32(&w.runs); runs != 1 {
+		t.Errorf("Was expecting 1 run, instead got %d", runs)
+	}
+	if !second.Success() || second.CallID() != first.CallID() {
+		t.Errorf("Was expecting the stored outcome of call %d, instead got call %d", first.CallID(), second.CallID())
+	}
+	b.Retry(context.Background(), w, 3, time.Millisecond, WithIdempotencyKey("order-2"))
+	if runs := atomic.LoadInt32(&w.runs); runs != 2 {
+		t.Errorf("Was expecting 2 runs, instead got %d", runs)
+	}
+}
+
+func Test_retry_key_without_store(t *testing.T) {
+	b := New("name", time.Second, 5)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &flaky{counter: &counter{}}
+	b.Retry(context.Background(), w, 3, time.Millisecond, WithIdempotencyKey("order-1"))
+	b.Retry(context.Background(), w, 3, time.Millisecond, WithIdempotencyKey("order-1"))
+	if runs := atomic.LoadInt32(&w.runs); runs != 2 {
+		t.Errorf("Was expecting 2 runs, instead got %d", runs)
+	}
+}
+
+func Test_retry_invalid_attempts(t *testing.T) {
+	b := New("name", time.Second, 5)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &flaky{counter: &counter{}}
+	be := b.Retry(context.Background(), w, 0, time.Millisecond)
+	if be.Reason() != ReasonInvalid || be.Error() == "" {
+		t.Errorf("Was expecting %v, instead got %v", ReasonInvalid, be.Reason())
+	}
+	if runs := atomic.LoadInt32(&w.runs); runs != 0 {
+		t.Errorf("Was expecting no runs, instead got %d", runs)
+	}
+}
+
+func Test_memory_store_expires(t *testing.T) {
+	s := NewMemoryStore(10 * time.Millisecond).(*memoryStore)
+	s.Complete("order-1", Error{isSuccess: true})
+	if _, ok := s.Load("order-1"); !ok {
+		t.Errorf("Was expecting order-1 to be complete")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := s.Load("order-1"); ok {
+		t.Errorf("Was expecting order-1 to expire")
+	}
+	for i := 0; i < 100; i++ {
+		s.Complete(fmt.Sprint("order-", i), Error{isSuccess: true})
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		s.Complete(fmt.Sprint("key-", i), Error{isSuccess: true})
+	}
+	if n := len(s.outcomes); n > 128 {
+		t.Errorf("Was expecting expired keys to be dropped, instead got %d keys", n)
+	}
+}
@@ -0,0 +1,80 @@
+package breaker
+
+import "sync"
+
+// sequencer lets calls take turns in submission order, see WithSerializedCallbacks. A call waits for
+// its turn only when it has callbacks to run, a call done without callbacks is skipped
+type sequencer struct {
+	mu       sync.Mutex
+	turn     *sync.Cond
+	issued   uint64
+	next     uint64          // Ticket whose turn it is
+	finished map[uint64]bool // Tickets done ahead of their turn
+}
+
+func newSequencer() *sequencer {
+	s := &sequencer{finished: map[uint64]bool{}}
+	s.turn = sync.NewCond(&s.mu)
+	return s
+}
+
+// take issues the next ticket
+func (s *sequencer) take() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.issued
+	s.issued++
+	return n
+}
+
+// wait blocks until every earlier ticket is done
+func (s *sequencer) wait(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.next != n {
+		s.turn.Wait()
+	}
+}
+
+// done ends the turn of ticket n, or records it to be skipped when its turn comes
+func (s *sequencer) done(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished[n] = true
+	for s.finished[s.next] {
+		delete(s.finished, s.next)
+		s.next++
+	}
+	s.turn.Broadcast()
+}
+
+// takeTicket gives c its turn with WithSerializedCallbacks, unless it already has one
+func (c *call) takeTicket() {
+	if c.serial != nil && !c.ticketed {
+		c.ticket, c.ticketed = c.serial.take(), true
+	}
+}
+
+// runAt runs f once every ticket before n is done, then ends the turn of n
+func (s *sequencer) runAt(n uint64, f func()) {
+	s.wait(n)
+	defer s.done(n)
+	f()
+}
+
+// handOff takes the turn of c, unless it already has one, and returns its ticket for callbacks run
+// elsewhere. The call no longer holds the ticket, finish leaves it alone
+func (c *call) handOff() uint64 {
+	c.takeTicket()
+	c.ticketed = false
+	return c.ticket
+}
+
+// inOrder runs the callbacks f of call c, in order of admission with WithSerializedCallbacks
+func (c *call) inOrder(f func()) {
+	if c.serial == nil {
+		f()
+		return
+	}
+	c.serial.runAt(c.handOff(), f)
+}
@@ -0,0 +1,129 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ordered records the order its callbacks ran in, failing after delay
+type ordered struct {
+	id    int
+	delay time.Duration
+	mu    *sync.Mutex
+	log   *[]int
+}
+
+func (w *ordered) CommandFunc() { time.Sleep(w.delay) }
+func (w *ordered) DefaultFunc() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	*w.log = append(*w.log, w.id)
+}
+func (w *ordered) CleanupFunc() {}
+func (w *ordered) Name() string { return "ordered" }
+
+func Test_serialized_callbacks(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(10), WithSerializedCallbacks())
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var mu sync.Mutex
+	var log []int
+	var chs []chan Error
+	// Earlier calls time out later, their callbacks would run last without serialization
+	for i := 0; i < 5; i++ {
+		chs = append(chs, b.Execute(&ordered{id: i, delay: time.Second, mu: &mu, log: &log}, WithCallTimeout(time.Duration(50-10*i)*time.Millisecond)))
+		if !waitFor(func() bool { return b.Running() == i+1 }) {
+			t.Fatalf("Was expecting call %d to be admitted", i)
+		}
+	}
+	chs = append(chs, b.Execute(&wrapper3{}))
+	for range Collect(chs...) {
+	}
+	for i, id := range log {
+		if id != i {
+			t.Errorf("Was expecting callbacks in admission order, instead got %v", log)
+			break
+		}
+	}
+	if len(log) != 5 {
+		t.Errorf("Was expecting 5 callbacks, instead got %v", log)
+	}
+}
+
+type holdKey struct{}
+
+// heldLimiter holds acquisitions whose context carries holdKey until hold is closed
+type heldLimiter struct {
+	blockingLimiter
+	hold chan bool
+}
+
+func (l *heldLimiter) Acquire(ctx context.Context) bool {
+	if ctx.Value(holdKey{}) != nil {
+		select {
+		case <-l.hold:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return l.blockingLimiter.Acquire(ctx)
+}
+
+func Test_serialized_callbacks_blocking_limiter(t *testing.T) {
+	l := &heldLimiter{blockingLimiter: blockingLimiter{semaphore: make(chan bool, 1)}, hold: make(chan bool)}
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithLimiter(l), WithSerializedCallbacks())
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	// Submitted first, still queued when the later call times out holding the only token
+	queued := b.ExecuteContext(context.WithValue(context.Background(), holdKey{}, true), &counter{})
+	if !waitFor(func() bool { return b.Queued() == 1 }) {
+		t.Fatalf("Was expecting the first call to queue")
+	}
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	later := b.Execute(w)
+	if !waitFor(func() bool { return b.Running() == 1 }) {
+		t.Fatalf("Was expecting the later call to run")
+	}
+	close(l.hold)
+	for _, ch := range []chan Error{later, queued} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("Was expecting both calls to complete, the callbacks deadlocked")
+		}
+	}
+}
+
+func Test_serialized_rejection_fails_fast(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(10), WithSerializedCallbacks())
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	first := b.Execute(w)
+	if !waitFor(func() bool { return b.Running() == 1 }) {
+		t.Fatalf("Was expecting the first call to run")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rejected := &counter{}
+	select {
+	case err := <-b.ExecuteContext(ctx, rejected):
+		if err.Reason() != ReasonCanceled {
+			t.Errorf("Was expecting %v, instead got %v", ReasonCanceled, err.Reason())
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("Was expecting the rejection at once, instead it waited for the call in flight")
+	}
+	if atomic.LoadInt32(&rejected.defaults) != 0 {
+		t.Errorf("Was expecting the fallback to wait for the turn of the call admitted earlier")
+	}
+	close(w.release)
+	<-first
+	if !waitFor(func() bool { return atomic.LoadInt32(&rejected.defaults) == 1 }) {
+		t.Errorf("Was expecting the fallback to run once its turn came")
+	}
+}
@@ -0,0 +1,258 @@
+package breaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// State of a circuit as seen by clients
+type State int
+
+const (
+	StateClosed   State = iota // Circuit is taking load
+	StateOpen                  // Circuit tripped, waiting for healthcheck to repair it
+	StateHalfOpen              // Circuit admits a single trial call, its outcome closes or reopens the circuit
+	StateShutdown              // Circuit permanently shutdown
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	case StateShutdown:
+		return "shutdown"
+	}
+	return "unknown"
+}
+
+// State returns the current state of the circuit
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state()
+}
+
+// state must be called with mu held
+func (b *Breaker) state() State {
+	if b.isShutdown {
+		return StateShutdown
+	}
+	if b.halfOpen {
+		return StateHalfOpen
+	}
+	if !b.isOk {
+		return StateOpen
+	}
+	return StateClosed
+}
+
+// ForceOpen opens the circuit and pins it open, for instance while an operator works on the
+// downstream: the healthcheck leaves it open and calls are rejected until ClearOverride. Returns
+// false if the circuit was already forced open or is shut down
+func (b *Breaker) ForceOpen() bool {
+	from, to := b.drive(event{kind: eventForce})
+	return !from.forced && to.forced
+}
+
+// ForceOpenWithCancel is ForceOpen shedding the load already admitted too: the context of every call
+// in flight is canceled, their calls end canceled with the fallback. Commands not implementing
+// ContextCommand cannot be interrupted, their calls end at once but the commands run to completion in
+// the background, as after a timeout. Calls made WithBypass are left running. Returns false, canceling
+// nothing, if the circuit was already forced open or is shut down
+func (b *Breaker) ForceOpenWithCancel() bool {
+	if !b.ForceOpen() {
+		return false
+	}
+	b.cancelInFlight()
+	return true
+}
+
+// ClearOverride ends ForceOpen. The circuit starts over half open with its failure and success
+// counters zeroed, the next calls are trials evaluated by the normal trip logic rather than by
+// counters gone stale during the override. Returns false if the circuit was not forced open
+func (b *Breaker) ClearOverride() bool {
+	from, _ := b.drive(event{kind: eventClear})
+	return from.forced
+}
+
+// admitState rejects calls the state of the circuit does not allow, a half open circuit admits
+// a single trial call. Marks c as a trial when it is admitted by a half open circuit
+func (b *Breaker) admitState(c *call) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := b.machine()
+	switch m.state {
+	case StateClosed:
+		return nil
+	case StateHalfOpen:
+		if steps := b.recoverySteps; len(steps) > 0 {
+			stage := m.successes
+			if stage >= len(steps) {
+				stage = len(steps) - 1
+			}
+			if b.float64() < steps[stage] {
+				c.trial = true
+				return nil
+			}
+			return reject(ReasonOpen, errors.New("circuit is recovering, cannot run your command"))
+		}
+		if b.backgroundProbe != nil {
+			return reject(ReasonOpen, errors.New("circuit is half open, probing in background, cannot run your command"))
+		}
+		if m.trial {
+			return reject(ReasonOpen, errors.New("circuit is half open, trial in progress, cannot run your command"))
+		}
+		next, _ := step(m, event{kind: eventTrial}, stepConfig{})
+		b.setMachine(next)
+		c.trial = true
+		return nil
+	case StateShutdown:
+		return reject(ReasonShutdown, errors.New("circuit has been permanently shutdown. create a new one"))
+	}
+	return reject(ReasonOpen, errors.New("circuit is open, cannot run your command"))
+}
+
+// Allowed reports whether a call would be admitted right now, without taking a token. It is only
+// advisory, the answer may be stale by the time Execute is called. Lets clients skip expensive
+// preparation of a call that would be rejected anyway
+func (b *Breaker) Allowed() bool {
+	if b.parent != nil && !b.parent.Allowed() {
+		return false
+	}
+	b.mu.Lock()
+	m := b.machine()
+	b.mu.Unlock()
+	switch {
+	case m.state == StateClosed:
+	case m.state == StateHalfOpen && !m.trial && b.backgroundProbe == nil:
+	default:
+		return false
+	}
+	return b.limiter.Available()
+}
+
+// OnStateChange registers a callback invoked once for every transition of the circuit.
+// The callback is invoked outside of any lock, it must not block
+func (b *Breaker) OnStateChange(f func(name string, from, to State)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onStateChange = f
+}
+
+// OnShutdown registers a callback invoked once when the breaker is shut down, after the state
+// changed, for cleanup such as closing pools or flushing metrics. Concurrent calls to Shutdown invoke
+// it only once, from the caller that actually shut the breaker down, outside of any lock
+func (b *Breaker) OnShutdown(f func(name string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onShutdown = f
+}
+
+func (b *Breaker) notifyStateChange(from, to State, reason string) {
+	b.mu.Lock()
+	b.transitions.add(Transition{Time: b.clock.Now(), From: from, To: to, Reason: reason})
+	if to == StateOpen {
+		b.lastProbe = b.clock.Now()
+	}
+	f := b.onStateChange
+	if b.stateChanged != nil {
+		close(b.stateChanged)
+		b.stateChanged = nil
+	}
+	b.mu.Unlock()
+	if to == StateOpen {
+		b.tripped()
+		b.dependencyOpened()
+	}
+	if to == StateHalfOpen && b.backgroundProbe != nil {
+		go b.probeInBackground()
+	}
+	if f != nil {
+		f(b.name, from, to)
+	}
+}
+
+// WaitClosed blocks until the circuit is closed, returns nil at once if it already is. Returns the
+// error of ctx when it is done first, and a shutdown Error once the circuit is shut down. With
+// WithLazyRecovery an open circuit only recovers when calls are made
+func (b *Breaker) WaitClosed(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		state := b.state()
+		if b.stateChanged == nil {
+			b.stateChanged = make(chan struct{})
+		}
+		changed := b.stateChanged
+		b.mu.Unlock()
+		switch state {
+		case StateClosed:
+			return nil
+		case StateShutdown:
+			return shutdownError()
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Transition records one change of state of the circuit
+type Transition struct {
+	Time   time.Time
+	From   State
+	To     State
+	Reason string
+}
+
+// transitionLog is a ring buffer of the most recent transitions, guarded by Breaker.mu
+type transitionLog struct {
+	entries []Transition
+	next    int
+	full    bool
+}
+
+func newTransitionLog(size int) *transitionLog {
+	if size < 0 {
+		size = 0
+	}
+	return &transitionLog{entries: make([]Transition, size)}
+}
+
+func (l *transitionLog) add(t Transition) {
+	if len(l.entries) == 0 {
+		return
+	}
+	l.entries[l.next] = t
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns the transitions oldest first
+func (l *transitionLog) list() []Transition {
+	if !l.full {
+		return append([]Transition(nil), l.entries[:l.next]...)
+	}
+	return append(append([]Transition(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// Transitions returns the most recent transitions of the circuit, oldest first
+func (b *Breaker) Transitions() []Transition {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.transitions.list()
+}
+
+// MarshalText renders the state by name, for JSON output
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -0,0 +1,578 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_state_change_fires_once(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithRecoveryPolicy(CapacityAvailable))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var changes []State
+	b.OnStateChange(func(name string, from, to State) {
+		changes = append(changes, to)
+	})
+	b.trip("test")
+	b.trip("test")
+	if b.State() != StateOpen {
+		t.Errorf("Was expecting open, instead got %v", b.State())
+	}
+	b.triggerHealthCheck()
+	b.triggerHealthCheck()
+	if b.State() != StateClosed {
+		t.Errorf("Was expecting closed, instead got %v", b.State())
+	}
+	if b.apply(event{kind: eventProbe}) {
+		t.Errorf("Closing a closed circuit should not report a transition")
+	}
+	if len(changes) != 2 || changes[0] != StateOpen || changes[1] != StateClosed {
+		t.Errorf("Was expecting [open closed], instead got %v", changes)
+	}
+}
+
+func Test_shutdown_once(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(10 * time.Millisecond)
+	var wg sync.WaitGroup
+	var won int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.Shutdown() {
+				atomic.AddInt32(&won, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Errorf("Exactly one caller should have shut down the circuit, instead %d did", won)
+	}
+	if b.State() != StateShutdown {
+		t.Errorf("Was expecting shutdown, instead got %v", b.State())
+	}
+}
+
+func Test_probe_drives_recovery(t *testing.T) {
+	var healthy int32
+	probe := func() error {
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("downstream still down")
+		}
+		return nil
+	}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithProbe(probe))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	b.triggerHealthCheck()
+	if b.State() != StateOpen {
+		t.Errorf("Failing probe should keep circuit open, instead got %v", b.State())
+	}
+	atomic.StoreInt32(&healthy, 1)
+	b.triggerHealthCheck()
+	if b.State() != StateClosed {
+		t.Errorf("Successful probe should close circuit, instead got %v", b.State())
+	}
+}
+
+func Test_transition_history_wraps(t *testing.T) {
+	c := newFakeClock()
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithTransitionHistory(3), WithRecoveryPolicy(CapacityAvailable), withClock(c))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	for i := 0; i < 2; i++ {
+		c.Add(time.Second)
+		b.trip("test")
+		c.Add(time.Second)
+		b.triggerHealthCheck()
+	}
+	got := b.Transitions()
+	if len(got) != 3 {
+		t.Fatalf("Was expecting 3 transitions, instead got %v", got)
+	}
+	want := []State{StateClosed, StateOpen, StateClosed}
+	for i := range got {
+		if got[i].To != want[i] {
+			t.Errorf("Transition %d was expecting %v, instead got %v", i, want[i], got[i].To)
+		}
+		if i > 0 && !got[i].Time.After(got[i-1].Time) {
+			t.Errorf("Transitions should be ordered oldest first, got %v", got)
+		}
+	}
+	if got[1].Reason != "test" || got[2].Reason != "repaired" {
+		t.Errorf("Unexpected reasons %v", got)
+	}
+}
+
+func Test_close_stops_healthcheck(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	b.trip("test")
+	if err := b.Close(); err != nil {
+		t.Errorf("Close should not fail, got %v", err)
+	}
+	select {
+	case <-b.stopped:
+	default:
+		t.Errorf("Healthcheck goroutine should have stopped")
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("Second Close should not fail, got %v", err)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Close should leave state intact, instead got %v", b.State())
+	}
+}
+
+func Test_defer_close_leaks_no_goroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+	func() {
+		b := New("name", time.Second, 1)
+		defer b.Close()
+		if err := <-b.Execute(&wrapper{}); !err.Success() {
+			t.Errorf("Was expecting success, instead got %v", err)
+		}
+	}()
+	if !waitFor(func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Errorf("Was expecting %d goroutines after Close, instead got %d", before, runtime.NumGoroutine())
+	}
+}
+
+func Test_ttl_shuts_down(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithTTL(10*time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	select {
+	case <-b.stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("Healthcheck should have stopped when TTL expired")
+	}
+	if b.State() != StateShutdown {
+		t.Errorf("Was expecting shutdown after TTL, instead got %v", b.State())
+	}
+	if err := <-b.Execute(&wrapper3{}); !err.Shutdown() {
+		t.Errorf("Was expecting shutdown error, instead got %v", err)
+	}
+}
+
+func Test_allowed(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if !b.Allowed() {
+		t.Errorf("Closed circuit should allow calls")
+	}
+	b.trip("test")
+	if b.Allowed() {
+		t.Errorf("Open circuit should not allow calls")
+	}
+	b.triggerHealthCheck()
+	if !b.Allowed() {
+		t.Errorf("Half open circuit should allow a trial")
+	}
+	if err := <-b.Execute(&wrapper3{}); !err.Success() {
+		t.Errorf("Was expecting success, instead got %v", err)
+	}
+	w := &blocker{release: make(chan bool)}
+	ch := b.Execute(w)
+	waitFor(func() bool { return b.limiter.InFlight() == 1 })
+	if b.Allowed() {
+		t.Errorf("Saturated circuit should not allow calls")
+	}
+	close(w.release)
+	<-ch
+	b.Shutdown()
+	if b.Allowed() {
+		t.Errorf("Shutdown circuit should not allow calls")
+	}
+}
+
+func Test_allowed_asks_limiter(t *testing.T) {
+	shared := NewLimiter(1)
+	a := NewWithOptions("a", WithTimeout(time.Second), WithLimiter(shared))
+	a.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer a.Shutdown()
+	b := NewWithOptions("b", WithTimeout(time.Second), WithConcurrency(10), WithLimiter(shared))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if !a.Allowed() || !b.Allowed() {
+		t.Errorf("Was expecting calls allowed with a token free in the shared limiter")
+	}
+	w := &blocker{release: make(chan bool)}
+	ch := a.Execute(w)
+	waitFor(func() bool { return shared.InFlight() == 1 })
+	if b.Allowed() {
+		t.Errorf("Was expecting no call allowed once the shared limiter is exhausted")
+	}
+	if err := <-b.Execute(&wrapper3{}); err.Reason() != ReasonSaturated {
+		t.Errorf("Was expecting %v, instead got %v", ReasonSaturated, err.Reason())
+	}
+	close(w.release)
+	<-ch
+}
+
+func Test_startup_probe(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithStartupProbe(func() error { return errors.New("down") }))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if b.State() != StateOpen {
+		t.Errorf("Failed startup probe should start the circuit open, instead got %v", b.State())
+	}
+	if err := <-b.Execute(&wrapper3{}); err.Reason() != ReasonOpen {
+		t.Errorf("Was expecting a rejection, instead got %v", err)
+	}
+	b2 := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithStartupProbe(func() error { return nil }))
+	b2.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b2.Shutdown()
+	if b2.State() != StateClosed {
+		t.Errorf("Successful startup probe should start the circuit closed, instead got %v", b2.State())
+	}
+}
+
+func Test_gradual_recovery(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1),
+		WithGradualRecovery([]float64{0.1, 0.5, 1}), WithRand(rand.New(rand.NewSource(1))))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	b.triggerHealthCheck()
+	admitted := func() int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if b.admitState(&call{}) == nil {
+				n++
+			}
+		}
+		return n
+	}
+	low, high := []int{50, 400, 1000}, []int{150, 600, 1000}
+	for stage := range low {
+		if b.State() != StateHalfOpen {
+			t.Fatalf("Was expecting half open at stage %d, instead got %v", stage, b.State())
+		}
+		if n := admitted(); n < low[stage] || n > high[stage] {
+			t.Errorf("Stage %d: Was expecting between %d and %d admitted, instead got %d", stage, low[stage], high[stage], n)
+		}
+		b.record(OutcomeSuccess, Error{isSuccess: true})
+	}
+	if b.State() != StateClosed {
+		t.Errorf("Success at the last step should close the circuit, instead got %v", b.State())
+	}
+}
+
+func Test_live_healthcheck_config(t *testing.T) {
+	b := New("name", time.Second, 1)
+	defer b.Shutdown()
+	b.trip("test")
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			b.SetHealthCheckInterval(time.Duration(i%3+1) * time.Millisecond)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			b.SetRecoveryPolicy(func(*Breaker) bool { return false })
+		}
+	}()
+	wg.Wait()
+	b.SetRecoveryPolicy(CapacityAvailable)
+	if !waitFor(func() bool { return b.State() == StateClosed }) {
+		t.Errorf("Was expecting the new policy to repair the circuit, instead got %v", b.State())
+	}
+	if got := b.Config().HealthCheckInterval; got < time.Millisecond || got > 3*time.Millisecond {
+		t.Errorf("Was expecting the new interval, instead got %v", got)
+	}
+}
+
+func Test_half_open_timeout(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithHalfOpenTimeout(20*time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if err := <-b.Execute(&counter{}); err.EffectiveTimeout() != time.Second {
+		t.Errorf("Was expecting the normal timeout when closed, instead got %v", err.EffectiveTimeout())
+	}
+	b.trip("test")
+	b.triggerHealthCheck()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	start := time.Now()
+	err := <-b.Execute(w)
+	if !err.Timeout() || err.EffectiveTimeout() != 20*time.Millisecond || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Was expecting the trial to time out after 20ms, instead got %v after %v", err.EffectiveTimeout(), time.Since(start))
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Was expecting the failed trial to reopen the circuit, instead got %v", b.State())
+	}
+}
+
+func Test_clear_override(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(10*time.Millisecond), WithConcurrency(2), WithFailureThreshold(3))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	defer close(w.release)
+	<-b.Execute(w)
+	<-b.Execute(w)
+	if !b.ForceOpen() || b.ForceOpen() {
+		t.Errorf("Was expecting only the first ForceOpen to force the circuit")
+	}
+	b.triggerHealthCheck()
+	if b.State() != StateOpen {
+		t.Errorf("Was expecting the healthcheck to leave a forced circuit open, instead got %v", b.State())
+	}
+	if err := <-b.Execute(&counter{}); err.Reason() != ReasonOpen {
+		t.Errorf("Was expecting a forced circuit to reject, instead got %v", err)
+	}
+	if !b.ClearOverride() || b.ClearOverride() {
+		t.Errorf("Was expecting only the first ClearOverride to clear")
+	}
+	if b.State() != StateHalfOpen || b.ConsecutiveFailures() != 0 {
+		t.Errorf("Was expecting half open with fresh counters, instead got %v and %d failures", b.State(), b.ConsecutiveFailures())
+	}
+	if err := <-b.Execute(&counter{}); !err.Success() || b.State() != StateClosed {
+		t.Errorf("Was expecting a fresh trial to close the circuit, instead got %v and %v", err, b.State())
+	}
+	<-b.Execute(w)
+	if b.State() != StateClosed {
+		t.Errorf("Stale failures should not count after the override, instead got %v", b.State())
+	}
+}
+
+func Test_lazy_recovery(t *testing.T) {
+	before := runtime.NumGoroutine()
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithLazyRecovery())
+	defer b.Shutdown()
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Was expecting no background goroutine, instead got %d more", after-before)
+	}
+	b.SetHealthCheckInterval(20 * time.Millisecond)
+	b.trip("test")
+	if err := <-b.Execute(&counter{}); err.Reason() != ReasonOpen {
+		t.Errorf("Was expecting a rejection before the interval elapsed, instead got %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := <-b.Execute(&counter{}); !err.Success() {
+		t.Errorf("Was expecting the next call to probe and run as the trial, instead got %v", err)
+	}
+	if b.State() != StateClosed {
+		t.Errorf("Was expecting the trial to close the circuit, instead got %v", b.State())
+	}
+	b.Close()
+}
+
+func Test_on_shutdown_fires_once(t *testing.T) {
+	b := New("name", time.Second, 1)
+	var calls int32
+	b.OnShutdown(func(name string) {
+		if name != "name" || b.State() != StateShutdown {
+			t.Errorf("Was expecting the callback after the shutdown of name, instead got %s %v", name, b.State())
+		}
+		atomic.AddInt32(&calls, 1)
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Shutdown()
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("Was expecting the callback to fire once, instead got %d", calls)
+	}
+}
+
+func Test_bypass_open_circuit(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	if err := <-b.Execute(&counter{}); err.Reason() != ReasonOpen {
+		t.Errorf("Was expecting a normal call to be rejected, instead got %v", err)
+	}
+	var ran int32
+	if err := <-b.Execute(&running{counter: &counter{}, ran: &ran}, WithBypass()); !err.Success() || ran != 1 {
+		t.Errorf("Was expecting the bypass call to run, instead got %v", err)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Was expecting the bypass call to leave the circuit open, instead got %v", b.State())
+	}
+	b.limiter.Acquire(context.Background())
+	defer b.limiter.Release()
+	if err := <-b.Execute(&counter{}, WithBypass()); err.Reason() != ReasonSaturated {
+		t.Errorf("Was expecting the bypass call to respect capacity, instead got %v", err)
+	}
+}
+
+func Test_wait_closed(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	if err := b.WaitClosed(context.Background()); err != nil {
+		t.Errorf("Was expecting nil for a closed circuit, instead got %v", err)
+	}
+	b.trip("test")
+	woke := make(chan error)
+	go func() { woke <- b.WaitClosed(context.Background()) }()
+	select {
+	case err := <-woke:
+		t.Errorf("Was expecting the waiter to block while open, instead got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	b.apply(event{kind: eventProbe})
+	select {
+	case err := <-woke:
+		if err != nil {
+			t.Errorf("Was expecting nil, instead got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Was expecting the waiter to wake when the circuit closed")
+	}
+	b.trip("test")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.WaitClosed(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Was expecting %v, instead got %v", context.DeadlineExceeded, err)
+	}
+	go b.Shutdown()
+	if err := b.WaitClosed(context.Background()); err == nil || !err.(Error).Shutdown() {
+		t.Errorf("Was expecting a shutdown error, instead got %v", err)
+	}
+}
+
+func Test_background_probe(t *testing.T) {
+	var healthy int32
+	probes := make(chan struct{}, 100)
+	probe := func() error {
+		probes <- struct{}{}
+		if atomic.LoadInt32(&healthy) == 0 {
+			return errors.New("still down")
+		}
+		return nil
+	}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), WithBackgroundProbe(probe, time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.trip("test")
+	b.triggerHealthCheck()
+	<-probes
+	<-probes
+	var ran int32
+	for i := 0; i < 3; i++ {
+		be := <-b.Execute(&running{counter: &counter{}, ran: &ran})
+		if be.Reason() != ReasonOpen {
+			t.Errorf("Was expecting user traffic rejected while probing, instead got %v", be.Reason())
+		}
+	}
+	if atomic.LoadInt32(&ran) != 0 || b.Allowed() {
+		t.Errorf("Was expecting no user call to serve as the trial")
+	}
+	atomic.StoreInt32(&healthy, 1)
+	if !waitFor(func() bool { return b.State() == StateClosed }) {
+		t.Errorf("Was expecting %v after the probe succeeded, instead got %v", StateClosed, b.State())
+	}
+	<-b.Execute(&running{counter: &counter{}, ran: &ran})
+	if atomic.LoadInt32(&ran) != 1 {
+		t.Errorf("Was expecting user traffic to run once closed")
+	}
+}
+
+// waiter runs until its context is done and records the cancellation
+type waiter struct {
+	*counter
+	started  chan bool
+	canceled int32
+}
+
+func (w *waiter) CommandFuncCtx(ctx context.Context) {
+	w.started <- true
+	<-ctx.Done()
+	atomic.StoreInt32(&w.canceled, 1)
+}
+
+func Test_force_open_with_cancel(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Minute), WithConcurrency(3))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var calls []chan Error
+	var waiters []*waiter
+	for i := 0; i < 2; i++ {
+		w := &waiter{counter: &counter{}, started: make(chan bool, 1)}
+		calls = append(calls, b.Execute(w))
+		<-w.started
+		waiters = append(waiters, w)
+	}
+	admin := &waiter{counter: &counter{}, started: make(chan bool, 1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	adminCall := b.ExecuteContext(ctx, admin, WithBypass())
+	<-admin.started
+	if !b.ForceOpenWithCancel() || b.ForceOpenWithCancel() {
+		t.Errorf("Was expecting only the first ForceOpenWithCancel to force the circuit")
+	}
+	for i, ch := range calls {
+		select {
+		case err := <-ch:
+			if err.Reason() != ReasonCanceled {
+				t.Errorf("Was expecting %v, instead got %v", ReasonCanceled, err.Reason())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Was expecting the call in flight to be canceled")
+		}
+		if !waitFor(func() bool { return atomic.LoadInt32(&waiters[i].canceled) == 1 }) || atomic.LoadInt32(&waiters[i].defaults) != 1 {
+			t.Errorf("Was expecting the command canceled and its fallback run")
+		}
+	}
+	if atomic.LoadInt32(&admin.canceled) != 0 || b.State() != StateOpen {
+		t.Errorf("Was expecting the bypass call left running and the circuit open")
+	}
+	cancel()
+	<-adminCall
+}
+
+func Test_events_after_shutdown_keep_status(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.Shutdown()
+	for _, ev := range []event{{kind: eventProbe}, {kind: eventTrip, reason: "test"}, {kind: eventForce}, {kind: eventClear}} {
+		if b.apply(ev) || b.status != iShutdown {
+			t.Errorf("Was expecting a shut down breaker to keep its status, instead got %d", b.status)
+		}
+	}
+}
+
+func Test_concurrent_force_open(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		b := New("name", time.Second, 1)
+		b.SetHealthCheckInterval(100000 * time.Millisecond)
+		var forced int32
+		var wg sync.WaitGroup
+		start := make(chan bool)
+		for j := 0; j < 8; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if b.ForceOpen() {
+					atomic.AddInt32(&forced, 1)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if forced != 1 || b.State() != StateOpen {
+			t.Fatalf("Was expecting one ForceOpen to force the circuit open, instead got %d and %v", forced, b.State())
+		}
+		b.Shutdown()
+	}
+}
@@ -0,0 +1,124 @@
+package breaker
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point in time view of breaker internals, for debugging and metrics
+type Stats struct {
+	Goroutines    int64         // Live goroutines spawned by Execute, includes commands still running after a timeout
+	AvgWaitTime   time.Duration // Average time admitted calls waited for admission
+	TripsLastHour int           // Times the circuit opened in the last hour
+	WouldTrip     int64         // Times the circuit opened in dry run, see WithDryRun
+	WouldReject   int64         // Calls admitted in dry run that would have been rejected
+	// Rejected calls split by cause: an open circuit calls for fixing the downstream, saturation for
+	// more capacity. Rejections for other reasons, such as shutdown, are in neither
+	RejectedOpen      int64
+	RejectedSaturated int64
+	latencies         [numOutcomes][]uint64
+	waits             []uint64
+}
+
+// WaitPercentiles returns the approximate time admitted calls waited for admission at each of the
+// percentiles p (0 to 100)
+func (s Stats) WaitPercentiles(p ...float64) []time.Duration {
+	d := make([]time.Duration, len(p))
+	for i := range p {
+		d[i] = percentile(s.waits, p[i])
+	}
+	return d
+}
+
+// Count returns the calls that completed with outcome
+func (s Stats) Count(outcome Outcome) uint64 {
+	var n uint64
+	for _, c := range s.latencies[outcome] {
+		n += c
+	}
+	return n
+}
+
+// LatencyPercentiles returns, for each outcome, the approximate latency at each of the
+// percentiles p (0 to 100)
+func (s Stats) LatencyPercentiles(p ...float64) map[Outcome][]time.Duration {
+	m := make(map[Outcome][]time.Duration, numOutcomes)
+	for o := Outcome(0); o < numOutcomes; o++ {
+		d := make([]time.Duration, len(p))
+		for i := range p {
+			d[i] = percentile(s.latencies[o], p[i])
+		}
+		m[o] = d
+	}
+	return m
+}
+
+// Running returns the admitted calls whose command has not completed yet. A command still running
+// after its call timed out is no longer counted, see Stats.Goroutines
+func (b *Breaker) Running() int {
+	return int(atomic.LoadInt64(&b.running))
+}
+
+// Queued returns the calls waiting for admission. Calls only queue behind a blocking Limiter, near
+// zero Queued with Running at the concurrency calls for more concurrency, a growing Queued for more
+// capacity downstream
+func (b *Breaker) Queued() int {
+	return int(atomic.LoadInt64(&b.queued))
+}
+
+// ConsecutiveFailures returns the failures since the last success, the circuit trips when they
+// reach the failure threshold, see WithFailureThreshold
+func (b *Breaker) ConsecutiveFailures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
+
+// ConsecutiveSuccesses returns the successful trials of a half open circuit, the circuit closes when
+// they reach the success threshold, see WithSuccessThreshold. Zero unless half open
+func (b *Breaker) ConsecutiveSuccesses() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.successes
+}
+
+// Stats returns a snapshot of the breaker statistics, empty for a breaker not created with New
+func (b *Breaker) Stats() Stats {
+	if b == nil || b.limiter == nil {
+		return Stats{}
+	}
+	s := Stats{
+		Goroutines:        atomic.LoadInt64(&b.goroutines),
+		TripsLastHour:     b.tripsSince(b.clock.Now().Add(-tripWindow)),
+		WouldTrip:         atomic.LoadInt64(&b.wouldTrip),
+		WouldReject:       atomic.LoadInt64(&b.wouldReject),
+		RejectedOpen:      atomic.LoadInt64(&b.rejectedOpen),
+		RejectedSaturated: atomic.LoadInt64(&b.rejectedSaturated),
+	}
+	for o := range b.latencies {
+		s.latencies[o] = b.latencies[o].snapshot()
+	}
+	s.waits = b.waits.snapshot()
+	if n := atomic.LoadInt64(&b.waitCount); n > 0 {
+		s.AvgWaitTime = time.Duration(atomic.LoadInt64(&b.waitTotal) / n)
+	}
+	return s
+}
+
+// ResetStats zeros the latency and wait statistics and the trip count, for instance at deploy time.
+// The state of the circuit is left untouched. Calls completing during the reset may be partially counted
+func (b *Breaker) ResetStats() {
+	for o := range b.latencies {
+		b.latencies[o].reset()
+	}
+	b.waits.reset()
+	atomic.StoreInt64(&b.waitCount, 0)
+	atomic.StoreInt64(&b.waitTotal, 0)
+	atomic.StoreInt64(&b.wouldTrip, 0)
+	atomic.StoreInt64(&b.wouldReject, 0)
+	atomic.StoreInt64(&b.rejectedOpen, 0)
+	atomic.StoreInt64(&b.rejectedSaturated, 0)
+	b.mu.Lock()
+	b.trips = nil
+	b.mu.Unlock()
+}
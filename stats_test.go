@@ -0,0 +1,257 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it is true or a second has passed
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 1000; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func Test_goroutine_limit(t *testing.T) {
+	b := NewWithOptions("name", WithTimeout(time.Minute), WithConcurrency(10), WithMaxGoroutines(2))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	ch := b.Execute(w)
+	if !waitFor(func() bool { return b.Stats().Goroutines == 2 }) {
+		t.Fatalf("Was expecting 2 goroutines, instead got %d", b.Stats().Goroutines)
+	}
+	err := <-b.Execute(w)
+	if err.Success() || err.Err == nil {
+		t.Errorf("Execute should have been rejected, goroutine budget is spent")
+	}
+	close(w.release)
+	if err := <-ch; !err.Success() {
+		t.Errorf("First command should have succeeded, instead got %v", err)
+	}
+	if !waitFor(func() bool { return b.Stats().Goroutines == 0 }) {
+		t.Errorf("Was expecting 0 goroutines, instead got %d", b.Stats().Goroutines)
+	}
+}
+
+func Test_goroutine_limit_is_hard(t *testing.T) {
+	const limit = 5
+	b := NewWithOptions("name", WithTimeout(5*time.Millisecond), WithConcurrency(100), WithMaxGoroutines(limit), WithHedge(time.Millisecond))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	var peak int64
+	stop := make(chan bool)
+	sampled := make(chan bool)
+	go func() {
+		defer close(sampled)
+		for {
+			if n := atomic.LoadInt64(&b.goroutines); n > peak {
+				peak = n
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				<-b.Execute(w)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-sampled
+	close(w.release)
+	if peak > limit {
+		t.Errorf("Was expecting at most %d goroutines, instead got %d", limit, peak)
+	}
+	if !waitFor(func() bool { return b.Stats().Goroutines == 0 }) {
+		t.Errorf("Was expecting 0 goroutines, instead got %d", b.Stats().Goroutines)
+	}
+}
+
+func Test_wait_time_recorded(t *testing.T) {
+	l := &slowLimiter{chanLimiter: *newChanLimiter(1), wait: 20 * time.Millisecond}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var waited time.Duration
+	b.OnAcquire(func(d time.Duration) { waited = d })
+	if err := <-b.Execute(&wrapper3{}); !err.Success() {
+		t.Fatalf("Was expecting success, instead got %v", err)
+	}
+	if waited < 20*time.Millisecond {
+		t.Errorf("OnAcquire should report the wait, got %v", waited)
+	}
+	s := b.Stats()
+	if s.AvgWaitTime < 20*time.Millisecond {
+		t.Errorf("Average wait should be at least 20ms, got %v", s.AvgWaitTime)
+	}
+	if p := s.WaitPercentiles(50); p[0] < 18*time.Millisecond {
+		t.Errorf("Median wait should be near 20ms, got %v", p[0])
+	}
+}
+
+func Test_flap_detection(t *testing.T) {
+	c := newFakeClock()
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), withClock(c))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	var flaps []int
+	b.OnFlap(2, time.Minute, func(name string, trips int) { flaps = append(flaps, trips) })
+	for i := 0; i < 4; i++ {
+		b.trip("test")
+		b.apply(event{kind: eventProbe})
+		c.Add(time.Second)
+	}
+	if len(flaps) != 2 || flaps[0] != 3 || flaps[1] != 4 {
+		t.Errorf("Was expecting flaps at the 3rd and 4th trips, instead got %v", flaps)
+	}
+	c.Add(2 * time.Minute)
+	b.trip("test")
+	if len(flaps) != 2 {
+		t.Errorf("Trips out of the window should not flap, instead got %v", flaps)
+	}
+	if got := b.Stats().TripsLastHour; got != 5 {
+		t.Errorf("Was expecting 5 trips in the last hour, instead got %d", got)
+	}
+	c.Add(time.Hour)
+	if got := b.Stats().TripsLastHour; got != 1 {
+		t.Errorf("Was expecting 1 trip in the last hour, instead got %d", got)
+	}
+}
+
+func Test_running_and_queued(t *testing.T) {
+	l := &blockingLimiter{semaphore: make(chan bool, 1)}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithLimiter(l))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	var chs []chan Error
+	for i := 0; i < 3; i++ {
+		chs = append(chs, b.Execute(w))
+	}
+	if !waitFor(func() bool { return b.Running() == 1 && b.Queued() == 2 }) {
+		t.Errorf("Was expecting 1 running and 2 queued, instead got %d and %d", b.Running(), b.Queued())
+	}
+	if s := b.Snapshot(); s.Running != 1 || s.Queued != 2 {
+		t.Errorf("Was expecting counts in snapshot, instead got %+v", s)
+	}
+	close(w.release)
+	for _, ch := range chs {
+		<-ch
+	}
+	if b.Running() != 0 || b.Queued() != 0 {
+		t.Errorf("Was expecting nothing running or queued, instead got %d and %d", b.Running(), b.Queued())
+	}
+}
+
+func Test_reset_stats(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	<-b.Execute(&wrapper3{})
+	b.trip("test")
+	if s := b.Stats(); s.LatencyPercentiles(100)[OutcomeSuccess][0] == 0 || s.TripsLastHour != 1 {
+		t.Fatalf("Was expecting statistics before reset")
+	}
+	b.ResetStats()
+	s := b.Stats()
+	if s.LatencyPercentiles(100)[OutcomeSuccess][0] != 0 || s.TripsLastHour != 0 || s.AvgWaitTime != 0 {
+		t.Errorf("Was expecting statistics to be zeroed, instead got %+v", s)
+	}
+	if b.State() != StateOpen {
+		t.Errorf("Reset should leave the state intact, instead got %v", b.State())
+	}
+}
+
+func Test_in_flight_calls(t *testing.T) {
+	b := New("name", time.Second, 2)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	w := &blocker{release: make(chan bool)}
+	ch := b.Execute(w)
+	waitFor(func() bool { return len(b.InFlightCalls()) == 1 })
+	calls := b.InFlightCalls()
+	if len(calls) != 1 || calls[0].Name != w.Name() || calls[0].Since.IsZero() {
+		t.Errorf("Was expecting the blocked command in flight, instead got %+v", calls)
+	}
+	close(w.release)
+	<-ch
+	if !waitFor(func() bool { return len(b.InFlightCalls()) == 0 }) {
+		t.Errorf("Was expecting no call in flight, instead got %+v", b.InFlightCalls())
+	}
+}
+
+func Test_avg_wait_time(t *testing.T) {
+	b := New("name", time.Second, 10)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	for i := uint64(1); i <= 64; i++ {
+		b.recordWait(time.Duration(i) * time.Millisecond)
+	}
+	if got := b.Stats().AvgWaitTime; got != 32500*time.Microsecond {
+		t.Errorf("Was expecting an average of 32.5ms, instead got %v", got)
+	}
+}
+
+func Test_rejections_by_cause(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	b.limiter.Acquire(context.Background())
+	<-b.Execute(&counter{})
+	b.limiter.Release()
+	for i := 0; i < 3; i++ {
+		<-b.Execute(&counter{})
+	}
+	s := b.Stats()
+	if s.RejectedSaturated != 1 || s.RejectedOpen != 3 {
+		t.Errorf("Was expecting 1 saturated and 3 open rejections, instead got %d and %d", s.RejectedSaturated, s.RejectedOpen)
+	}
+}
+
+// backwardClock steps back a second on every reading, like a wall clock set back by NTP. Its
+// readings lack the monotonic clock so differences between them are negative
+type backwardClock struct {
+	*fakeClock
+}
+
+func (c *backwardClock) Now() time.Time {
+	c.Add(-time.Second)
+	return c.fakeClock.Now()
+}
+
+func Test_durations_never_negative(t *testing.T) {
+	c := &backwardClock{fakeClock: newFakeClock()}
+	b := NewWithOptions("name", WithTimeout(time.Second), WithConcurrency(1), withClock(c))
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	d := int64(-1)
+	b.OnComplete(func(name string, outcome Outcome, latency time.Duration) { atomic.StoreInt64(&d, int64(latency)) })
+	be := <-b.Execute(&counter{})
+	if be.QueueDuration() < 0 || be.ServiceDuration() < 0 {
+		t.Errorf("Was expecting durations of at least 0, instead got %v and %v", be.QueueDuration(), be.ServiceDuration())
+	}
+	if !waitFor(func() bool { return atomic.LoadInt64(&d) >= 0 }) {
+		t.Errorf("Was expecting a latency of at least 0, instead got %v", time.Duration(atomic.LoadInt64(&d)))
+	}
+	if p := b.Stats().LatencyPercentiles(100)[OutcomeSuccess]; p[0] < 0 || p[0] > time.Second {
+		t.Errorf("Was expecting a latency near 0, instead got %v", p[0])
+	}
+}
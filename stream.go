@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+)
+
+// StreamingCommand is implemented by clients whose command produces values incrementally, see ExecuteStream
+type StreamingCommand[T any] interface {
+	Name() string
+	// Stream emits values until it is done. emit returns false once the call is over, timed out or
+	// canceled, Stream must then return promptly. A non nil error fails the call
+	Stream(ctx context.Context, emit func(T) bool) error
+	DefaultFunc()
+	CleanupFunc()
+}
+
+// stream adapts a StreamingCommand to CommandFuncs
+type stream[T any] struct {
+	commands StreamingCommand[T]
+	values   chan T
+	err      error
+	mu       sync.Mutex // Serializes emit and close
+	closed   bool
+}
+
+// ExecuteStream runs a streaming command under the admission and timeout of b, the timeout bounding
+// the whole stream. Values are received until the channel is closed, the result of the call is then
+// available on the Error channel. A consumer that stops reading must cancel ctx, otherwise the
+// command is blocked in emit until it times out
+func ExecuteStream[T any](ctx context.Context, b *Breaker, commands StreamingCommand[T], opts ...CallOption) (<-chan T, <-chan Error) {
+	s := &stream[T]{commands: commands, values: make(chan T)}
+	errorch := make(chan Error, 1)
+	go func() {
+		be := <-b.ExecuteContext(ctx, s, opts...)
+		s.close()
+		errorch <- be
+	}()
+	return s.values, errorch
+}
+
+func (s *stream[T]) CommandFuncCtx(ctx context.Context) {
+	s.err = s.commands.Stream(ctx, func(v T) bool { return s.emit(ctx, v) })
+}
+
+func (s *stream[T]) emit(ctx context.Context, v T) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || ctx.Err() != nil {
+		return false
+	}
+	select {
+	case s.values <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// close ends the stream, waiting for an emit in progress, which returns once the call is over
+func (s *stream[T]) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	close(s.values)
+}
+
+func (s *stream[T]) failure() error { return s.err }
+func (s *stream[T]) CommandFunc()   { s.CommandFuncCtx(context.Background()) }
+func (s *stream[T]) DefaultFunc()   { s.commands.DefaultFunc() }
+func (s *stream[T]) CleanupFunc()   { s.commands.CleanupFunc() }
+func (s *stream[T]) Name() string   { return s.commands.Name() }
@@ -0,0 +1,78 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// counting emits n values and then either fails with err or blocks until the call is over
+type counting struct {
+	n   int
+	err error
+}
+
+func (c *counting) Stream(ctx context.Context, emit func(int) bool) error {
+	for i := 1; i <= c.n; i++ {
+		if !emit(i) {
+			return nil
+		}
+	}
+	if c.err != nil {
+		return c.err
+	}
+	<-ctx.Done()
+	return nil
+}
+func (c *counting) DefaultFunc() {}
+func (c *counting) CleanupFunc() {}
+func (c *counting) Name() string { return "counting" }
+
+func Test_stream_times_out(t *testing.T) {
+	b := New("name", 20*time.Millisecond, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	values, errs := ExecuteStream[int](context.Background(), b, &counting{n: 3})
+	var got []int
+	for v := range values {
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[2] != 3 {
+		t.Errorf("Was expecting 3 values, instead got %v", got)
+	}
+	if err := <-errs; !err.Timeout() {
+		t.Errorf("Was expecting a timeout, instead got %v", err)
+	}
+}
+
+func Test_stream_fails(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	broken := errors.New("broken")
+	values, errs := ExecuteStream[int](context.Background(), b, &counting{n: 2, err: broken})
+	n := 0
+	for range values {
+		n++
+	}
+	err := <-errs
+	if n != 2 || err.Reason() != ReasonFailed || !errors.Is(err, broken) {
+		t.Errorf("Was expecting 2 values and the stream error, instead got %d and %v", n, err)
+	}
+}
+
+func Test_stream_consumer_cancels(t *testing.T) {
+	b := New("name", time.Second, 1)
+	b.SetHealthCheckInterval(100000 * time.Millisecond)
+	defer b.Shutdown()
+	ctx, cancel := context.WithCancel(context.Background())
+	values, errs := ExecuteStream[int](ctx, b, &counting{n: 100})
+	<-values
+	cancel()
+	for range values {
+	}
+	if err := <-errs; err.Reason() != ReasonCanceled {
+		t.Errorf("Was expecting the call to be canceled, instead got %v", err)
+	}
+}